@@ -0,0 +1,71 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/appflight/apkparser"
+)
+
+// 解析AndroidManifest.xml，返回启动图标在apk中的路径，不解码图标
+func apkIconName(zr *apkparser.ZipReader) (string, error) {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+	p, err := apkparser.NewParser(zr, enc)
+	if err != nil {
+		return "", &CorruptError{Format: "apk", Offset: -1, Err: err}
+	}
+	if err = p.ParseXml("AndroidManifest.xml"); err != nil {
+		return "", &CorruptError{Format: "apk", Offset: -1, Err: err}
+	}
+	enc.Flush()
+
+	var manifest apkparser.Manifest
+	xml.Unmarshal(buf.Bytes(), &manifest)
+	name, _ := manifest.App.Icon.String()
+	if name == "" || zr.File[name] == nil {
+		return "", fmt.Errorf("%w: no icon in apk", ErrNoIcon)
+	}
+	return name, nil
+}
+
+// 读取apk的启动图标，返回其路径和原始数据，只检查这一个文件的大小
+func apkIcon(r io.ReaderAt, size int64, cfg ...Config) (string, []byte, error) {
+	// 混淆过的apk的zip头可能无法被archive/zip解析，使用apkparser的读取器，条目数和大小按其文件列表检查
+	if err := limitsOf(cfg...).checkZipDir(r, size); err != nil {
+		return "", nil, err
+	}
+	az, err := apkparser.OpenZipReader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return "", nil, &CorruptError{Format: "apk", Offset: -1, Err: err}
+	}
+	defer az.Close()
+
+	if err = checkApk(az, cfg...); err != nil {
+		return "", nil, err
+	}
+
+	name, err := apkIconName(az)
+	if err != nil {
+		return "", nil, err
+	}
+
+	f := az.File[name]
+	if h := f.ZipHeader(); h != nil {
+		if err = limitsOf(cfg...).checkBytes(int64(min(h.UncompressedSize64, 1<<63-1))); err != nil {
+			return "", nil, err
+		}
+	}
+	if err = f.Open(); err != nil {
+		return "", nil, &CorruptError{Format: "apk", Offset: -1, Err: err}
+	}
+	defer f.Close()
+
+	d, err := readAll(f, cfg...)
+	if err != nil {
+		return "", nil, err
+	}
+	return name, d, nil
+}
@@ -0,0 +1,482 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/wingdi/ns-wingdi-bitmapinfoheader
+type BITMAPINFOHEADER struct {
+	Size            uint32 // 结构体大小，40
+	Width           int32  // 宽度
+	Height          int32  // 高度，ico中是XOR和AND两部分的高度之和
+	Planes          uint16 // 颜色平面数，必须为1
+	BitCount        uint16 // 每个像素的位数
+	Compression     uint32 // 压缩方式
+	SizeImage       uint32 // 图像数据的大小
+	XPelsPerMeter   int32  // 水平分辨率
+	YPelsPerMeter   int32  // 垂直分辨率
+	ColorsUsed      uint32 // 调色板颜色数
+	ColorsImportant uint32 // 重要颜色数
+}
+
+// 每行按4字节对齐的字节数
+func dibStride(w, bitCount int) int {
+	return (w*bitCount + 31) >> 5 << 2
+}
+
+// 将图片编码为ico中的32位位图条目：BITMAPINFOHEADER + 自下而上的BGRA像素 + 1位AND掩码
+func bmp32Res(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	xorStride, andStride := dibStride(w, 32), dibStride(w, 1)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &BITMAPINFOHEADER{
+		Size:      40,
+		Width:     int32(w),
+		Height:    int32(h << 1),
+		Planes:    1,
+		BitCount:  32,
+		SizeImage: uint32((xorStride + andStride) * h),
+	})
+
+	xor, and := make([]byte, xorStride*h), make([]byte, andStride*h)
+	for y := 0; y < h; y++ {
+		// 自下而上
+		row := h - 1 - y
+		for x := 0; x < w; x++ {
+			c := color.NRGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA)
+			o := row*xorStride + x<<2
+			xor[o], xor[o+1], xor[o+2], xor[o+3] = c.B, c.G, c.R, c.A
+			// 完全透明的像素在AND掩码中置1
+			if c.A == 0 {
+				and[row*andStride+x>>3] |= 0x80 >> uint(x&7)
+			}
+		}
+	}
+
+	buf.Write(xor)
+	buf.Write(and)
+	return buf.Bytes()
+}
+
+// 将图片编码为ico中的位图条目，bitCount为32、8或4，8位和4位使用中位切分量化后的调色板
+func bmpRes(img image.Image, bitCount int) []byte {
+	if bitCount != 8 && bitCount != 4 {
+		return bmp32Res(img)
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	xorStride, andStride := dibStride(w, bitCount), dibStride(w, 1)
+
+	// 半透明以下的像素视为透明，透明像素使用黑色（AND掩码置1，XOR为0）
+	var transparent bool
+	pixels := make([]color.NRGBA, 0, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 0x80 {
+				transparent = true
+			}
+			pixels = append(pixels, c)
+		}
+	}
+
+	n := 1 << uint(bitCount)
+	var pal color.Palette
+	if transparent {
+		pal = append(pal, color.NRGBA{0, 0, 0, 0xFF})
+		n--
+	}
+	pal = append(pal, medianCut(pixels, n)...)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &BITMAPINFOHEADER{
+		Size:      40,
+		Width:     int32(w),
+		Height:    int32(h << 1),
+		Planes:    1,
+		BitCount:  uint16(bitCount),
+		SizeImage: uint32((xorStride + andStride) * h),
+	})
+
+	// RGBQUAD调色板，补齐到2^bitCount个
+	quad := make([]byte, 4<<uint(bitCount))
+	for i, c := range pal {
+		r, g, b, _ := c.RGBA()
+		quad[i<<2], quad[i<<2+1], quad[i<<2+2] = uint8(b>>8), uint8(g>>8), uint8(r>>8)
+	}
+	buf.Write(quad)
+
+	xor, and := make([]byte, xorStride*h), make([]byte, andStride*h)
+	for y := 0; y < h; y++ {
+		row := h - 1 - y
+		for x := 0; x < w; x++ {
+			c := pixels[y*w+x]
+
+			var idx int
+			if c.A < 0x80 {
+				and[row*andStride+x>>3] |= 0x80 >> uint(x&7)
+			} else {
+				c.A = 0xFF
+				idx = pal.Index(c)
+			}
+
+			if bitCount == 8 {
+				xor[row*xorStride+x] = uint8(idx)
+			} else {
+				xor[row*xorStride+x>>1] |= uint8(idx) << (4 - uint(x&1)<<2)
+			}
+		}
+	}
+
+	buf.Write(xor)
+	buf.Write(and)
+	return buf.Bytes()
+}
+
+// 中位切分算法，将不透明像素量化为不超过n种颜色
+func medianCut(pixels []color.NRGBA, n int) color.Palette {
+	var colors [][3]uint8
+	uniq := make(map[[3]uint8]bool)
+	for _, c := range pixels {
+		if c.A < 0x80 {
+			continue
+		}
+		k := [3]uint8{c.R, c.G, c.B}
+		if !uniq[k] {
+			uniq[k] = true
+			colors = append(colors, k)
+		}
+	}
+
+	if len(colors) <= 0 {
+		return nil
+	}
+
+	boxes := [][][3]uint8{colors}
+	for len(boxes) < n {
+		// 选择颜色范围最大的盒子，沿范围最大的通道在中位数处切开
+		bi, ch, rng := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				lo, hi := uint8(0xFF), uint8(0)
+				for _, p := range box {
+					if p[c] < lo {
+						lo = p[c]
+					}
+					if p[c] > hi {
+						hi = p[c]
+					}
+				}
+				if int(hi-lo) > rng {
+					bi, ch, rng = i, c, int(hi-lo)
+				}
+			}
+		}
+		if bi < 0 {
+			break
+		}
+
+		box := boxes[bi]
+		sort.Slice(box, func(i, j int) bool { return box[i][ch] < box[j][ch] })
+		m := len(box) >> 1
+		boxes = append(boxes[:bi], append([][][3]uint8{box[:m], box[m:]}, boxes[bi+1:]...)...)
+	}
+
+	var pal color.Palette
+	for _, box := range boxes {
+		var r, g, b int
+		for _, p := range box {
+			r, g, b = r+int(p[0]), g+int(p[1]), b+int(p[2])
+		}
+		l := len(box)
+		pal = append(pal, color.NRGBA{uint8(r / l), uint8(g / l), uint8(b / l), 0xFF})
+	}
+	return pal
+}
+
+// 位图的压缩方式
+const (
+	biRGB            = 0
+	biRLE8           = 1
+	biRLE4           = 2
+	biBitfields      = 3
+	biAlphaBitfields = 6
+)
+
+/*
+解码ico中的位图条目，高度是XOR和AND两部分的高度之和：
+
+	头      BITMAPINFOHEADER(40)、V2(52)、V3(56)、V4(108)、V5(124)
+	压缩    BI_RGB、BI_BITFIELDS、BI_ALPHABITFIELDS、BI_RLE8、BI_RLE4
+	色深    1、4、8位调色板，16、24、32位真彩色，每行按4字节对齐
+	透明    32位使用Alpha通道，Alpha全为0时与其他色深一样使用AND掩码
+*/
+func res2BMP32(d []byte) (*image.RGBA, error) {
+	var hdr BITMAPINFOHEADER
+	if err := binary.Read(bytes.NewReader(d), binary.LittleEndian, &hdr); err != nil {
+		return nil, corrupt("bmp", -1, "bitmap header truncated")
+	}
+	if hdr.Size < 40 || int64(hdr.Size) > int64(len(d)) {
+		return nil, corrupt("bmp", -1, fmt.Sprintf("invalid bitmap header size %d", hdr.Size))
+	}
+
+	// 高度为负数时自上而下
+	w, h := int(hdr.Width), int(hdr.Height)
+	topDown := h < 0
+	if topDown {
+		h = -h
+	}
+	rows := h >> 1
+	if w <= 0 || rows <= 0 {
+		return nil, corrupt("bmp", -1, "invalid bitmap size")
+	}
+
+	bc := int(hdr.BitCount)
+	switch hdr.Compression {
+	case biRGB:
+		switch bc {
+		case 1, 4, 8, 16, 24, 32:
+		default:
+			return nil, corrupt("bmp", -1, fmt.Sprintf("unsupported bit count %d", bc))
+		}
+	case biRLE8, biRLE4:
+		if hdr.Compression == biRLE8 && bc != 8 || hdr.Compression == biRLE4 && bc != 4 {
+			return nil, corrupt("bmp", -1, fmt.Sprintf("bit count %d does not match RLE compression", bc))
+		}
+	case biBitfields, biAlphaBitfields:
+		if bc != 16 && bc != 32 {
+			return nil, corrupt("bmp", -1, fmt.Sprintf("bit count %d does not match bitfields compression", bc))
+		}
+	default:
+		return nil, corrupt("bmp", -1, fmt.Sprintf("unsupported compression %d", hdr.Compression))
+	}
+
+	le := binary.LittleEndian
+	truncated := corrupt("bmp", -1, "bitmap data truncated")
+	off := int(hdr.Size)
+
+	// 颜色掩码：R、G、B、A
+	var masks [4]uint32
+	switch bc {
+	case 16:
+		masks = [4]uint32{0x7C00, 0x03E0, 0x001F, 0}
+	case 32:
+		masks = [4]uint32{0xFF0000, 0xFF00, 0xFF, 0xFF000000}
+	}
+	if hdr.Compression == biBitfields || hdr.Compression == biAlphaBitfields {
+		// V2及以上的头中自带掩码（V3起包含Alpha），否则跟在头后面
+		n, o := 3, 40
+		if hdr.Compression == biAlphaBitfields || hdr.Size >= 56 {
+			n = 4
+		}
+		if hdr.Size < 52 {
+			o, off = off, off+n<<2
+		}
+		if o+n<<2 > len(d) {
+			return nil, truncated
+		}
+		masks[3] = 0
+		for i := 0; i < n; i++ {
+			masks[i] = le.Uint32(d[o+i<<2:])
+		}
+	}
+
+	// 调色板，颜色数少于2^bc时，超出的索引为黑色
+	var pal []color.NRGBA
+	colors := int(hdr.ColorsUsed)
+	if bc <= 8 {
+		if colors <= 0 || colors > 1<<bc {
+			colors = 1 << bc
+		}
+		if off+colors<<2 > len(d) {
+			return nil, truncated
+		}
+		pal = make([]color.NRGBA, 1<<bc)
+		for i := 0; i < colors; i++ {
+			q := d[off+i<<2:]
+			pal[i] = color.NRGBA{q[2], q[1], q[0], 0xFF} // RGBQUAD BGR
+		}
+		off += colors << 2
+	} else if colors > 0 {
+		// 真彩色也可能带有用于优化显示的调色板
+		if int64(off)+int64(colors)<<2 > int64(len(d)) {
+			return nil, truncated
+		}
+		off += colors << 2
+	}
+
+	// 像素数据，RLE解码为每行w个的调色板索引
+	stride := dibStride(w, bc)
+	var pix []byte
+	rle := hdr.Compression == biRLE8 || hdr.Compression == biRLE4
+	if rle {
+		n := int(hdr.SizeImage)
+		if n <= 0 || off+n > len(d) || int64(w)*int64(rows) > int64(n)<<7 {
+			return nil, truncated
+		}
+		pix, stride = rleDecode(d[off:off+n], w, rows, bc), w
+		off += n
+	} else {
+		if int64(stride)*int64(rows) > int64(len(d)-off) {
+			return nil, truncated
+		}
+		pix = d[off : off+stride*rows]
+		off += stride * rows
+	}
+
+	// AND掩码，每行同样按4字节对齐，缺失时视为不透明
+	andStride := dibStride(w, 1)
+	var and []byte
+	if off+andStride*rows <= len(d) {
+		and = d[off : off+andStride*rows]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, rows))
+	alpha := bc == 32 && masks[3] != 0
+	var hasAlpha bool
+	for y := 0; y < rows; y++ {
+		// 自下而上存储
+		row := rows - 1 - y
+		if topDown {
+			row = y
+		}
+		p := pix[row*stride:]
+		for x := 0; x < w; x++ {
+			var c color.NRGBA
+			switch {
+			case rle:
+				c = pal[p[x]]
+			case bc == 8:
+				c = pal[p[x]]
+			case bc == 4:
+				c = pal[p[x>>1]>>(4-uint(x&1)<<2)&0x0F]
+			case bc == 1:
+				c = pal[p[x>>3]>>(7-uint(x&7))&1]
+			case bc == 16:
+				v := uint32(le.Uint16(p[x<<1:]))
+				c = color.NRGBA{maskBits(v, masks[0]), maskBits(v, masks[1]), maskBits(v, masks[2]), 0xFF}
+			case bc == 24:
+				c = color.NRGBA{p[x*3+2], p[x*3+1], p[x*3], 0xFF}
+			case bc == 32:
+				v := le.Uint32(p[x<<2:])
+				c = color.NRGBA{maskBits(v, masks[0]), maskBits(v, masks[1]), maskBits(v, masks[2]), 0xFF}
+				if alpha {
+					c.A = maskBits(v, masks[3])
+					hasAlpha = hasAlpha || c.A != 0
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 没有Alpha通道，或者Alpha全为0时，AND掩码置1的像素透明
+	if !hasAlpha {
+		for y := 0; y < rows; y++ {
+			row := rows - 1 - y
+			if topDown {
+				row = y
+			}
+			for x := 0; x < w; x++ {
+				o := img.PixOffset(x, y)
+				img.Pix[o+3] = 0xFF
+				if len(and) > 0 && and[row*andStride+x>>3]>>(7-uint(x&7))&1 != 0 {
+					img.Pix[o], img.Pix[o+1], img.Pix[o+2], img.Pix[o+3] = 0, 0, 0, 0
+				}
+			}
+		}
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+	return rgba, nil
+}
+
+// 按掩码取出颜色分量并缩放到8位
+func maskBits(v, m uint32) uint8 {
+	if m == 0 {
+		return 0
+	}
+	v = (v & m) >> uint(bits.TrailingZeros32(m))
+	full := uint64(1)<<uint(bits.OnesCount32(m)) - 1
+	return uint8(min(uint64(v)*0xFF/full, 0xFF))
+}
+
+/*
+RLE8、RLE4解码为自下而上、每行w个的调色板索引，两个字节一组：
+
+	n > 0      重复n个像素，RLE4时两个索引交替
+	0, 0       行结束
+	0, 1       位图结束
+	0, 2       跳过dx、dy
+	0, n >= 3  后面n个像素不压缩，按2字节对齐
+*/
+func rleDecode(src []byte, w, rows, bc int) []byte {
+	ret := make([]byte, w*rows)
+	x, y := 0, 0
+	put := func(v byte) {
+		if x < w && y < rows {
+			ret[y*w+x] = v
+		}
+		x++
+	}
+
+	for i := 0; i+1 < len(src); {
+		n, c := int(src[i]), src[i+1]
+		i += 2
+		if n > 0 {
+			for k := 0; k < n; k++ {
+				if bc == 4 {
+					put(c >> (4 - uint(k&1)<<2) & 0x0F)
+				} else {
+					put(c)
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case 0:
+			x, y = 0, y+1
+		case 1:
+			return ret
+		case 2:
+			if i+1 >= len(src) {
+				return ret
+			}
+			x, y = x+int(src[i]), y+int(src[i+1])
+			i += 2
+		default:
+			n = int(c)
+			size := n
+			if bc == 4 {
+				size = (n + 1) >> 1
+			}
+			if i+size > len(src) {
+				return ret
+			}
+			for k := 0; k < n; k++ {
+				if bc == 4 {
+					put(src[i+k>>1] >> (4 - uint(k&1)<<2) & 0x0F)
+				} else {
+					put(src[i+k])
+				}
+			}
+			i += (size + 1) &^ 1
+		}
+	}
+	return ret
+}
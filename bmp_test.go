@@ -0,0 +1,212 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image/color"
+	"testing"
+)
+
+// 手工构造的DIB，头、掩码、调色板、像素和AND掩码依次排列
+type dib struct {
+	size  uint32   // header size, 40 if zero
+	w, h  int32    // image size, h is doubled in the header and negative for top-down
+	bc    uint16   // bit count
+	comp  uint32   // compression
+	masks []uint32 // inside V2+ headers, otherwise after the header
+	pal   []uint32 // 0x00RRGGBB
+	pix   []byte   // rows already padded to 4 bytes, or the RLE stream
+	and   []byte
+}
+
+func (d dib) bytes() []byte {
+	size := d.size
+	if size == 0 {
+		size = 40
+	}
+	hdr := make([]byte, size)
+	le := binary.LittleEndian
+	le.PutUint32(hdr, size)
+	le.PutUint32(hdr[4:], uint32(d.w))
+	le.PutUint32(hdr[8:], uint32(d.h*2))
+	le.PutUint16(hdr[12:], 1)
+	le.PutUint16(hdr[14:], d.bc)
+	le.PutUint32(hdr[16:], d.comp)
+	le.PutUint32(hdr[20:], uint32(len(d.pix)))
+	le.PutUint32(hdr[32:], uint32(len(d.pal)))
+
+	var buf bytes.Buffer
+	masks := make([]byte, 4*len(d.masks))
+	for i, m := range d.masks {
+		le.PutUint32(masks[i*4:], m)
+	}
+	if size >= 52 {
+		copy(hdr[40:], masks)
+		buf.Write(hdr)
+	} else {
+		buf.Write(hdr)
+		buf.Write(masks)
+	}
+	for _, c := range d.pal {
+		binary.Write(&buf, le, c)
+	}
+	buf.Write(d.pix)
+	buf.Write(d.and)
+	return buf.Bytes()
+}
+
+func TestRes2BMP32(t *testing.T) {
+	var (
+		red   = color.RGBA{0xFF, 0, 0, 0xFF}
+		green = color.RGBA{0, 0xFF, 0, 0xFF}
+		blue  = color.RGBA{0, 0, 0xFF, 0xFF}
+		white = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+		black = color.RGBA{0, 0, 0, 0xFF}
+		half  = color.RGBA{0x80, 0, 0, 0x80} // 预乘后的半透明红色
+		clear = color.RGBA{}
+	)
+	pal := []uint32{0x000000, 0xFF0000, 0x00FF00, 0x0000FF}
+	// 自下而上：先是下面一行蓝、白，再是上面一行红、绿
+	bgra := []byte{0xFF, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0xFF, 0xFF, 0, 0xFF, 0, 0xFF}
+	rgb565 := []byte{0x1F, 0x00, 0xFF, 0xFF, 0x00, 0xF8, 0xE0, 0x07}
+
+	tests := []struct {
+		name string
+		data []byte
+		w    int
+		want []color.RGBA // 自上而下逐行
+		err  bool
+	}{
+		{
+			name: "rgb 1",
+			data: dib{w: 2, h: 2, bc: 1, pal: []uint32{0x000000, 0xFFFFFF}, pix: []byte{0x40, 0, 0, 0, 0x80, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{white, black, black, white},
+		},
+		{
+			name: "rgb 4",
+			data: dib{w: 2, h: 2, bc: 4, pal: pal, pix: []byte{0x30, 0, 0, 0, 0x12, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, black},
+		},
+		{
+			name: "rgb 8",
+			data: dib{w: 2, h: 2, bc: 8, pal: pal, pix: []byte{3, 0, 0, 0, 1, 2, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, black},
+		},
+		{
+			name: "rgb 16",
+			data: dib{w: 2, h: 2, bc: 16, pix: []byte{0x1F, 0x00, 0xFF, 0x7F, 0x00, 0x7C, 0xE0, 0x03}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			name: "rgb 24",
+			data: dib{w: 2, h: 2, bc: 24, pix: []byte{0xFF, 0, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0, 0xFF, 0, 0xFF, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			name: "rgb 32 alpha",
+			data: dib{w: 2, h: 2, bc: 32, pix: []byte{0xFF, 0, 0, 0xFF, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0, 0, 0xFF, 0x80}}.bytes(),
+			w:    2, want: []color.RGBA{red, half, blue, clear},
+		},
+		{
+			// Alpha全为0时使用AND掩码，下面一行第二个像素透明
+			name: "rgb 32 and mask",
+			data: dib{w: 2, h: 2, bc: 32, pix: []byte{0xFF, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0xFF, 0, 0, 0xFF, 0, 0}, and: []byte{0x40, 0, 0, 0, 0, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, clear},
+		},
+		{
+			name: "rgb 8 and mask",
+			data: dib{w: 2, h: 2, bc: 8, pal: pal, pix: []byte{3, 0, 0, 0, 1, 2, 0, 0}, and: []byte{0, 0, 0, 0, 0x80, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{clear, green, blue, black},
+		},
+		{
+			name: "bitfields 16",
+			data: dib{w: 2, h: 2, bc: 16, comp: biBitfields, masks: []uint32{0xF800, 0x07E0, 0x001F}, pix: rgb565}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			// RGBA字节序，没有Alpha掩码时不透明
+			name: "bitfields 32",
+			data: dib{w: 2, h: 2, bc: 32, comp: biBitfields, masks: []uint32{0xFF, 0xFF00, 0xFF0000}, pix: []byte{0, 0, 0xFF, 0, 0xFF, 0xFF, 0xFF, 0, 0xFF, 0, 0, 0, 0, 0xFF, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			name: "alphabitfields 32",
+			data: dib{w: 2, h: 2, bc: 32, comp: biAlphaBitfields, masks: []uint32{0xFF, 0xFF00, 0xFF0000, 0xFF000000}, pix: []byte{0, 0, 0xFF, 0xFF, 0, 0, 0, 0, 0xFF, 0, 0, 0xFF, 0xFF, 0, 0, 0x80}}.bytes(),
+			w:    2, want: []color.RGBA{red, half, blue, clear},
+		},
+		{
+			name: "v4 bitfields 32",
+			data: dib{size: 108, w: 2, h: 2, bc: 32, comp: biBitfields, masks: []uint32{0xFF0000, 0xFF00, 0xFF, 0xFF000000}, pix: bgra}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			name: "v5 bitfields 16",
+			data: dib{size: 124, w: 2, h: 2, bc: 16, comp: biBitfields, masks: []uint32{0xF800, 0x07E0, 0x001F, 0}, pix: rgb565}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, white},
+		},
+		{
+			name: "v5 rgb 8",
+			data: dib{size: 124, w: 2, h: 2, bc: 8, pal: pal, pix: []byte{3, 0, 0, 0, 1, 2, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, green, blue, black},
+		},
+		{
+			// 自上而下，AND掩码同样自上而下
+			name: "top-down",
+			data: dib{w: 2, h: -2, bc: 8, pal: pal, pix: []byte{1, 2, 0, 0, 3, 0, 0, 0}, and: []byte{0x40, 0, 0, 0, 0, 0, 0, 0}}.bytes(),
+			w:    2, want: []color.RGBA{red, clear, blue, black},
+		},
+		{
+			// 下面一行不压缩的3个像素，上面一行重复3个
+			name: "rle8",
+			data: dib{w: 3, h: 2, bc: 8, comp: biRLE8, pal: pal, pix: []byte{0, 3, 3, 0, 1, 0, 0, 0, 3, 2, 0, 1}}.bytes(),
+			w:    3, want: []color.RGBA{green, green, green, blue, black, red},
+		},
+		{
+			name: "rle4",
+			data: dib{w: 3, h: 2, bc: 4, comp: biRLE4, pal: pal, pix: []byte{0, 3, 0x30, 0x10, 0, 0, 3, 0x12, 0, 1}}.bytes(),
+			w:    3, want: []color.RGBA{red, green, red, blue, black, red},
+		},
+		{
+			// 数据提前结束时剩余的像素使用索引0
+			name: "rle8 short stream",
+			data: dib{w: 2, h: 2, bc: 8, comp: biRLE8, pal: pal, pix: []byte{2, 3}}.bytes(),
+			w:    2, want: []color.RGBA{black, black, blue, blue},
+		},
+		{name: "truncated header", data: dib{w: 2, h: 2, bc: 8, pal: pal}.bytes()[:20], err: true},
+		{name: "truncated palette", data: dib{w: 2, h: 2, bc: 8, pal: pal, pix: []byte{3, 0, 0, 0, 1, 2, 0, 0}}.bytes()[:48], err: true},
+		{name: "truncated masks", data: dib{w: 2, h: 2, bc: 16, comp: biBitfields, masks: []uint32{0xF800, 0x07E0, 0x001F}}.bytes()[:44], err: true},
+		{name: "truncated pixels", data: dib{w: 2, h: 2, bc: 24, pix: make([]byte, 15)}.bytes(), err: true},
+		{name: "truncated rle", data: dib{w: 2, h: 2, bc: 8, comp: biRLE8, pal: pal, pix: []byte{2, 3, 0, 0, 2, 1, 0, 1}}.bytes()[:60], err: true},
+		{name: "rle bit count", data: dib{w: 2, h: 2, bc: 4, comp: biRLE8, pal: pal, pix: []byte{0, 1}}.bytes(), err: true},
+		{name: "bitfields bit count", data: dib{w: 2, h: 2, bc: 24, comp: biBitfields, masks: []uint32{0xFF, 0xFF00, 0xFF0000}}.bytes(), err: true},
+		{name: "bit count", data: dib{w: 2, h: 2, bc: 2, pix: make([]byte, 8)}.bytes(), err: true},
+		{name: "compression", data: dib{w: 2, h: 2, bc: 8, comp: 4, pix: make([]byte, 8)}.bytes(), err: true},
+		{name: "size", data: dib{w: 0, h: 2, bc: 32}.bytes(), err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := res2BMP32(tt.data)
+			if tt.err {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Fatalf("got %v, want ErrCorrupt", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			h := len(tt.want) / tt.w
+			if b := img.Bounds(); b.Dx() != tt.w || b.Dy() != h {
+				t.Fatalf("got %v, want %dx%d", b, tt.w, h)
+			}
+			for i, want := range tt.want {
+				if got := img.RGBAAt(i%tt.w, i/tt.w); got != want {
+					t.Errorf("pixel (%d, %d): got %v, want %v", i%tt.w, i/tt.w, got, want)
+				}
+			}
+		})
+	}
+}
@@ -3,8 +3,10 @@ package main
 import (
     "flag"
     "fmt"
+    "image/color"
     "os"
     "path/filepath"
+    "strconv"
     "strings"
 
     "github.com/orcastor/fico"
@@ -18,6 +20,20 @@ var (
     height     int
     index      int
     indexSet   bool
+    appearance string
+    sizes      string
+    bmp        bool
+    bitCount   int
+    oversize   string
+    noFallback bool
+    trim       bool
+    margin     float64
+    filter     string
+    sharpen    bool
+    fit        string
+    background string
+    maxPixels  int64
+    maxBytes   int64
 )
 
 func main() {
@@ -28,6 +44,20 @@ func main() {
     flag.IntVar(&width, "width", 32, "Image width")
     flag.IntVar(&height, "height", 32, "Image height")
     flag.IntVar(&index, "index", 0, "Image index (optional)")
+    flag.StringVar(&sizes, "sizes", "", "Comma separated entry sizes for multi-resolution output, e.g. 16,32,48,256 (optional)")
+    flag.BoolVar(&bmp, "bmp", false, "Encode entries smaller than 256 as BMP (optional)")
+    flag.IntVar(&bitCount, "bitcount", 32, "Bit depth of BMP entries: 32, 8 or 4 (optional)")
+    flag.StringVar(&oversize, "oversize", "", "Entries larger than 256 in ico output: downscale, reject or keep (optional)")
+    flag.StringVar(&appearance, "appearance", "", "ICNS appearance: normal, dark or selected (optional)")
+    flag.BoolVar(&noFallback, "nofallback", false, "Fail instead of using the default icon (optional)")
+    flag.BoolVar(&trim, "trim", false, "Trim transparent borders and re-center the content (optional)")
+    flag.Float64Var(&margin, "margin", 0, "Margin on each side after trimming as a fraction of the size, e.g. 0.0625 (optional)")
+    flag.StringVar(&filter, "filter", "", "Resampling filter: nearest, box, bilinear, catmullrom or lanczos (optional)")
+    flag.BoolVar(&sharpen, "sharpen", false, "Lightly sharpen images downscaled below 32 pixels (optional)")
+    flag.StringVar(&fit, "fit", "", "Fit mode for the target size: contain, cover, stretch or native (optional)")
+    flag.StringVar(&background, "background", "", "Background color as #RRGGBB or #RRGGBBAA, transparent by default (optional)")
+    flag.Int64Var(&maxPixels, "maxpixels", 0, "Max pixels of an image to decode, 0 for no limit (optional)")
+    flag.Int64Var(&maxBytes, "maxbytes", 0, "Max bytes of an input or a decompressed entry, 0 for no limit (optional)")
 
     flag.Parse()
 
@@ -38,6 +68,17 @@ func main() {
         os.Exit(1)
     }
 
+    // Parse background color
+    var bg color.Color
+    if background != "" {
+        c, err := parseColor(background)
+        if err != nil {
+            fmt.Printf("Invalid background color: %v\n", err)
+            os.Exit(1)
+        }
+        bg = c
+    }
+
     // Derive output path if not provided
     if outputPath == "" {
         baseName := strings.TrimSuffix(filepath.Base(inputPath), filepath.Ext(inputPath))
@@ -60,6 +101,10 @@ func main() {
     }
 
     // Use retrieved information
+    iconFile := inputPath
+    if info.IconFile != "" {
+        iconFile = info.IconFile
+    }
     if info.IconIndex != nil {
         index = *info.IconIndex
         indexSet = true
@@ -82,10 +127,42 @@ func main() {
         Index:  indexPtr,
         Width:  width,
         Height: height,
+
+        Appearance: appearance,
+        BMP:        bmp,
+        BitCount:   bitCount,
+        Oversize:   oversize,
+        NoFallback: noFallback,
+        Trim:       trim,
+        Margin:     margin,
+        Filter:     filter,
+        Sharpen:    sharpen,
+        Fit:        fit,
+        Background: bg,
+
+        Limits: fico.Limits{MaxPixels: maxPixels, MaxBytes: maxBytes},
+    }
+    for _, s := range strings.Split(sizes, ",") {
+        if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+            config.Sizes = append(config.Sizes, n)
+        }
+    }
+
+    // Export iconset directory
+    if format == "iconset" {
+        outputFile.Close()
+        os.Remove(outputPath)
+        err = fico.F2Iconset(outputPath, iconFile, config)
+        if err != nil {
+            fmt.Printf("Error exporting iconset: %v\n", err)
+            os.Exit(1)
+        }
+        fmt.Printf("%s -> %s\n", inputPath, outputPath)
+        return
     }
 
     // Call fico.F2ICO function
-    err = fico.F2ICO(outputFile, inputPath, config)
+    err = fico.F2ICO(outputFile, iconFile, config)
     if err != nil {
         fmt.Printf("Error converting icon: %v\n", err)
         os.Exit(1)
@@ -94,3 +171,19 @@ func main() {
     fmt.Printf("%s -> %s\n", inputPath, outputPath)
     fmt.Println("Icon conversion successful")
 }
+
+// parseColor parses #RRGGBB or #RRGGBBAA
+func parseColor(s string) (color.Color, error) {
+    hex := strings.TrimPrefix(s, "#")
+    if len(hex) == 6 {
+        hex += "ff"
+    }
+    if len(hex) != 8 {
+        return nil, fmt.Errorf("%q is not #RRGGBB or #RRGGBBAA", s)
+    }
+    v, err := strconv.ParseUint(hex, 16, 32)
+    if err != nil {
+        return nil, err
+    }
+    return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
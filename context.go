@@ -0,0 +1,169 @@
+package fico
+
+import (
+	"context"
+	"io"
+	"io/fs"
+)
+
+// 带ctx的配置，ctx通过Config传递到各个格式的处理中
+func withContext(ctx context.Context, cfg ...Config) Config {
+	c := Config{}
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	c.ctx = ctx
+	return c
+}
+
+// 是否已经取消或者超时，没有设置ctx时返回nil
+func ctxErr(cfg ...Config) error {
+	if len(cfg) > 0 && cfg[0].ctx != nil {
+		return cfg[0].ctx.Err()
+	}
+	return nil
+}
+
+// 取消后读取返回ctx的错误，用于中断zip、apk和PE等第三方库中的读取
+type ctxReaderAt struct {
+	ctx context.Context
+	r   io.ReaderAt
+}
+
+func (r ctxReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.ReadAt(p, off)
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// 处理失败时，如果是因为取消导致的，返回ctx的错误
+func ctxResult(err error, cfg ...Config) error {
+	if err == nil {
+		return nil
+	}
+	if e := ctxErr(cfg...); e != nil {
+		return e
+	}
+	return err
+}
+
+// 同F2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func F2ICOContext(ctx context.Context, w io.Writer, path string, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(F2ICO(w, path, c), c)
+}
+
+// 同FS2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func FS2ICOContext(ctx context.Context, w io.Writer, fsys fs.FS, path string, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(FS2ICO(w, fsys, path, c), c)
+}
+
+// 同R2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func R2ICOContext(ctx context.Context, w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(R2ICO(w, r, size, name, c), c)
+}
+
+// 同PE2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func PE2ICOContext(ctx context.Context, w io.Writer, path string, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(PE2ICO(w, path, c), c)
+}
+
+// 同ICNS2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func ICNS2ICOContext(ctx context.Context, w io.Writer, r io.Reader, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(ICNS2ICO(w, ctxReader{ctx, r}, c), c)
+}
+
+// 同ICO2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func ICO2ICOContext(ctx context.Context, w io.Writer, r io.Reader, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(ICO2ICO(w, ctxReader{ctx, r}, c), c)
+}
+
+// 同IMG2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func IMG2ICOContext(ctx context.Context, w io.Writer, r io.Reader, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(IMG2ICO(w, ctxReader{ctx, r}, c), c)
+}
+
+// 同RSRC2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func RSRC2ICOContext(ctx context.Context, w io.Writer, r io.Reader, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(RSRC2ICO(w, ctxReader{ctx, r}, c), c)
+}
+
+// 同AppleDouble2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func AppleDouble2ICOContext(ctx context.Context, w io.Writer, r io.Reader, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(AppleDouble2ICO(w, ctxReader{ctx, r}, c), c)
+}
+
+// 同Iconset2ICO，ctx取消或者超时后尽快返回ctx.Err()
+func Iconset2ICOContext(ctx context.Context, w io.Writer, dir string, cfg ...Config) error {
+	c := withContext(ctx, cfg...)
+	if err := ctxErr(c); err != nil {
+		return err
+	}
+	return ctxResult(Iconset2ICO(w, dir, c), c)
+}
+
+// 同GetInfo，ctx取消或者超时后尽快返回ctx.Err()
+func GetInfoContext(ctx context.Context, path string) (Info, error) {
+	if err := ctx.Err(); err != nil {
+		return Info{}, err
+	}
+	info, err := getInfo(ctx, osFS{}, path)
+	return info, ctxResult(err, withContext(ctx))
+}
+
+// 同GetInfoFS，ctx取消或者超时后尽快返回ctx.Err()
+func GetInfoFSContext(ctx context.Context, fsys fs.FS, path string) (Info, error) {
+	if err := ctx.Err(); err != nil {
+		return Info{}, err
+	}
+	info, err := getInfo(ctx, ioFS{fsys}, path)
+	return info, ctxResult(err, withContext(ctx))
+}
@@ -0,0 +1,364 @@
+package fico
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// DecodeLimits image.Decode、DecodeICO等无法传入Config的入口使用的限制，默认不限制
+var DecodeLimits Limits
+
+// 注册ico、cur和icns解码器，image.Decode 会返回其中最大的一张图片
+func init() {
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", DecodeICO, DecodeICOConfig)
+	image.RegisterFormat("cur", "\x00\x00\x02\x00", DecodeICO, DecodeICOConfig)
+	image.RegisterFormat("icns", "icns", DecodeICNS, DecodeICNSConfig)
+}
+
+// Icon 解码后的图标条目
+type Icon struct {
+	IconEntry
+	Image image.Image
+}
+
+// 最大的条目的下标：面积优先，其次色深，相同时取前面的
+func largestEntry(n int, entry func(i int) IconEntry) int {
+	best := 0
+	for i := 1; i < n; i++ {
+		e, b := entry(i), entry(best)
+		if e.Width*e.Height > b.Width*b.Height || e.Width*e.Height == b.Width*b.Height && e.BitCount > b.BitCount {
+			best = i
+		}
+	}
+	return best
+}
+
+// 最大的图标
+func largestIcon(icons []Icon) Icon {
+	return icons[largestEntry(len(icons), func(i int) IconEntry { return icons[i].IconEntry })]
+}
+
+// 解码ico（或cur）中的所有条目
+func DecodeAllICO(r io.Reader, cfg ...Config) ([]Icon, error) {
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return icoIcons(d, cfg...)
+}
+
+// 解码ico（或cur）数据中的所有条目，解码前检查尺寸
+func icoIcons(d []byte, cfg ...Config) ([]Icon, error) {
+	_, entries, err := parseICO(d, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	var icons []Icon
+	for _, e := range entries {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+
+		it, err := newICOImage(e.Data, int(e.BitCount))
+		if err != nil {
+			continue
+		}
+		if err = limitsOf(cfg...).checkPixels(it.Width, it.Height); err != nil {
+			return nil, err
+		}
+
+		img, err := entryImage(e.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		icon := Icon{IconEntry: icoEntry(it), Image: img}
+		icon.HotspotX, icon.HotspotY = e.HotspotX, e.HotspotY
+		icons = append(icons, icon)
+	}
+
+	if len(icons) <= 0 {
+		return nil, ErrNoIcon
+	}
+	return icons, nil
+}
+
+// 解码icoImage条目，解码前检查尺寸
+func itemIcons(items []icoImage, cfg ...Config) ([]Icon, error) {
+	var icons []Icon
+	for _, it := range items {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+		if err := limitsOf(cfg...).checkPixels(it.Width, it.Height); err != nil {
+			return nil, err
+		}
+
+		img, err := entryImage(it.Data)
+		if err != nil {
+			return nil, err
+		}
+		icons = append(icons, Icon{IconEntry: icoEntry(it), Image: img})
+	}
+
+	if len(icons) <= 0 {
+		return nil, ErrNoIcon
+	}
+	return icons, nil
+}
+
+// 解码单张图片，条目信息来自图片头
+func imageIcons(d []byte, cfg ...Config) ([]Icon, error) {
+	e, err := imageEntry(bytes.NewReader(d))
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := decodeImage(d, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return []Icon{{IconEntry: e, Image: img}}, nil
+}
+
+// 解码ico（或cur）中最大的一张图片
+func DecodeICO(r io.Reader) (image.Image, error) {
+	icons, err := DecodeAllICO(r, Config{Limits: DecodeLimits})
+	if err != nil {
+		return nil, err
+	}
+	return largestIcon(icons).Image, nil
+}
+
+// 返回ico（或cur）中最大一张图片的尺寸和颜色模型，不解码像素数据
+func DecodeICOConfig(r io.Reader) (image.Config, error) {
+	cfg := Config{Limits: DecodeLimits}
+	d, err := readAll(r, cfg)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	_, entries, err := parseICO(d, cfg)
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	items := icoImages(entries)
+	if len(items) <= 0 {
+		return image.Config{}, ErrNoIcon
+	}
+
+	best := items[largestEntry(len(items), func(i int) IconEntry { return icoEntry(items[i]) })]
+
+	if isPNG(best.Data) {
+		return png.DecodeConfig(bytes.NewReader(best.Data))
+	}
+	return image.Config{ColorModel: color.RGBAModel, Width: best.Width, Height: best.Height}, nil
+}
+
+// 解码icns中的所有图标成员（使用普通外观）
+func DecodeAllICNS(r io.Reader, cfg ...Config) ([]Icon, error) {
+	entries, err := icnsEntries(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return icnsIcons(entries)
+}
+
+// 同ICNS2ICO，Appearance选择外观，同尺寸的成员只保留一个
+func loadICNS(r io.Reader, cfg ...Config) ([]Icon, error) {
+	entries, err := icnsEntries(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return icnsIcons(bestICNSEntries(entries))
+}
+
+// 解码icns成员，PNG成员在这里解码
+func icnsIcons(entries []icnsEntry) ([]Icon, error) {
+	var icons []Icon
+	for _, e := range entries {
+		img := e.Image
+		if e.PNG != nil {
+			var err error
+			if img, err = png.Decode(bytes.NewReader(e.PNG)); err != nil {
+				return nil, err
+			}
+		}
+		icons = append(icons, Icon{IconEntry: e.entry(), Image: img})
+	}
+
+	if len(icons) <= 0 {
+		return nil, ErrNoIcon
+	}
+	return icons, nil
+}
+
+// 解码icns中最大的一张图片
+func DecodeICNS(r io.Reader) (image.Image, error) {
+	icons, err := DecodeAllICNS(r, Config{Limits: DecodeLimits})
+	if err != nil {
+		return nil, err
+	}
+	return largestIcon(icons).Image, nil
+}
+
+// 返回icns中最大一张图片的尺寸和颜色模型，不解码像素数据
+func DecodeICNSConfig(r io.Reader) (image.Config, error) {
+	d, err := readAll(r, Config{Limits: DecodeLimits})
+	if err != nil {
+		return image.Config{}, err
+	}
+
+	// 只读取成员头，不解码RLE、ARGB和PNG的像素数据
+	offsets := make(icnsOffsets)
+	set, err := parseICNS(d, 0, offsets)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if err = DecodeLimits.checkResources(len(set)); err != nil {
+		return image.Config{}, err
+	}
+	entries, err := icnsMemberEntries(set, offsets)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if len(entries) <= 0 {
+		return image.Config{}, ErrNoIcon
+	}
+
+	best := entries[largestEntry(len(entries), func(i int) IconEntry { return entries[i] })]
+
+	if best.Encoding == "png" {
+		for _, icon := range set {
+			if string(icon.Type[:]) == best.Type {
+				return png.DecodeConfig(bytes.NewReader(icon.Data))
+			}
+		}
+	}
+	return image.Config{ColorModel: color.RGBAModel, Width: best.Width, Height: best.Height}, nil
+}
+
+// 按配置提取图标的所有条目并解码，由各格式的处理器直接返回图片，不经过ico编码
+func loadIcons(fsys fileSystem, path string, cfg ...Config) ([]Icon, error) {
+	// macOS自定义图标：Icon\r 或者 ._Icon\r
+	if isMacIconFile(path) {
+		rsrc, err := macIconFork(fsys, path, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		return rsrcIcons(rsrc, cfg...)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".iconset", ".appiconset":
+		return iconsetIcons(fsys, path, cfg...)
+	}
+
+	r, size, c, err := openReaderAt(fsys, path, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	h := findHandler(r, size, path, cfg...)
+	if h == nil || h.Load == nil {
+		return nil, ErrUnsupportedFormat
+	}
+
+	*result(cfg...) = Result{Format: h.Name}
+	if len(cfg) > 0 && cfg[0].ctx != nil {
+		r = ctxReaderAt{cfg[0].ctx, r}
+	}
+	return h.Load(r, size, path, cfg...)
+}
+
+// 资源分支中的图标族
+func rsrcIcons(rsrc []byte, cfg ...Config) ([]Icon, error) {
+	d, err := rsrcICNS(bytes.NewReader(rsrc), cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return loadICNS(bytes.NewReader(d), cfg...)
+}
+
+// iconset目录中的所有尺寸的图片
+func iconsetIcons(fsys fileSystem, dir string, cfg ...Config) ([]Icon, error) {
+	imgs, err := readIconset(fsys, dir, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同iconset2ICO，相同像素尺寸的只保留一张
+	var icons []Icon
+	used := make(map[[2]int]bool)
+	for _, i := range imgs {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+
+		icon, err := imageIcons(i.Data, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		if size := [2]int{icon[0].Width, icon[0].Height}; !used[size] {
+			used[size] = true
+			icons = append(icons, icon...)
+		}
+	}
+	return icons, nil
+}
+
+// 按配置提取图标并返回解码后的图片，指定尺寸时返回最匹配的一张并缩放到该尺寸，否则返回最大的一张
+func Load(path string, cfg ...Config) (image.Image, error) {
+	icons, err := loadIcons(osFS{}, path, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同writeICO，色深最高的条目中尺寸最接近的
+	if len(cfg) > 0 && cfg[0].Width > 0 && cfg[0].Height > 0 {
+		var m, wdiff, hdiff, bm int
+		wdiff, hdiff = 0xFFFFF, 0xFFFFF
+		for i, e := range icons {
+			if e.BitCount >= bm {
+				bm = e.BitCount
+				if abs(e.Width-cfg[0].Width) <= wdiff && abs(e.Height-cfg[0].Height) <= hdiff {
+					wdiff, hdiff = abs(e.Width-cfg[0].Width), abs(e.Height-cfg[0].Height)
+					m = i
+				}
+			}
+		}
+		img, err := zoomImg(icons[m].Image, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		return img, nil
+	}
+
+	return retouchImg(largestIcon(icons).Image, cfg...)
+}
+
+// 提取图标中所有尺寸的图片，Width和Height不生效
+func LoadAll(path string, cfg ...Config) ([]image.Image, error) {
+	icons, err := loadIcons(osFS{}, path, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	var imgs []image.Image
+	for _, i := range icons {
+		img, err := retouchImg(i.Image, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
@@ -0,0 +1,164 @@
+package fico
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+/*
+目录图标的探测顺序（优先级从高到低）：
+
+ 1. desktop.ini       Windows文件夹自定义图标
+ 2. autorun.inf       Windows可移动介质/卷的图标
+ 3. .directory        KDE等桌面环境的文件夹图标
+ 4. Icon\r            macOS自定义文件夹图标（保存在资源分支中，或者AppleDouble的 ._Icon\r 中）
+ 5. .VolumeIcon.icns  macOS卷图标
+ 6. 包目录结构         *.app、*.framework、*.bundle、*.prefPane
+ 7. 图标集目录         *.iconset、*.appiconset本身
+
+配置文件不存在、解析失败或者没有配置图标时，继续尝试下一项；都没有找到时返回ErrNoIcon。
+*/
+func dirInfo(ctx context.Context, fsys fileSystem, dir string) (info Info, err error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return info, err
+	}
+
+	// Windows下的文件名不区分大小写
+	names := make(map[string]string, len(entries))
+	for _, e := range entries {
+		names[strings.ToLower(e.Name())] = e.Name()
+	}
+
+	for _, n := range []string{"desktop.ini", "autorun.inf", ".directory"} {
+		name, ok := names[n]
+		if !ok {
+			continue
+		}
+		if i, err := getInfo(ctx, fsys, fsys.Join(dir, name)); err == nil && i.IconFile != "" {
+			return i, nil
+		}
+	}
+
+	for _, n := range []string{"Icon\r", "._Icon\r", ".VolumeIcon.icns"} {
+		if name, ok := names[strings.ToLower(n)]; ok {
+			info.IconFile = fsys.Join(dir, name)
+			return
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(dir)) {
+	case ".app", ".framework", ".bundle", ".prefpane":
+		info.IconFile = bundleIcon(fsys, dir)
+	case ".iconset", ".appiconset":
+		info.IconFile = dir
+	}
+	if info.IconFile == "" {
+		return info, fmt.Errorf("%w: no icon in directory", ErrNoIcon)
+	}
+	return
+}
+
+// 配置文件中的相对路径相对于所在目录，只有文件确实存在时才替换，
+// 环境变量、盘符路径以及图标主题名（如folder-blue）原样返回
+func resolveIconFile(fsys fileSystem, dir, iconFile string) string {
+	if strings.ContainsAny(iconFile, "%:") || fsys.IsAbs(iconFile) ||
+		strings.HasPrefix(iconFile, `\`) || strings.HasPrefix(iconFile, "/") {
+		return iconFile
+	}
+
+	p := fsys.Join(dir, fsys.FromSlash(strings.ReplaceAll(iconFile, `\`, "/")))
+	if _, err := fsys.Stat(p); err == nil {
+		return p
+	}
+	return iconFile
+}
+
+/*
+macOS包目录结构：
+
+	*.app/Contents/Info.plist
+	*.app/Contents/Resources/AppIcon.icns
+	*.framework/Versions/Current/Resources/Info.plist
+	*.framework/Resources/Info.plist
+
+优先使用Info.plist中CFBundleIconFile指定的图标，其次是AppIcon.icns，
+再次是Resources下的第一个icns文件，都没有时返回默认的AppIcon.icns路径。
+*/
+func bundleIcon(fsys fileSystem, path string) string {
+	// Info.plist和资源目录
+	layouts := [][2]string{{"Contents/Info.plist", "Contents/Resources"}}
+	if strings.ToLower(filepath.Ext(path)) == ".framework" {
+		layouts = [][2]string{
+			{"Versions/Current/Resources/Info.plist", "Versions/Current/Resources"},
+			{"Resources/Info.plist", "Resources"},
+		}
+	}
+
+	for _, l := range layouts {
+		resDir := fsys.Join(path, l[1])
+		if d, err := fsys.ReadFile(fsys.Join(path, l[0])); err == nil {
+			if name := plistString(d, "CFBundleIconFile"); name != "" {
+				if filepath.Ext(name) == "" {
+					name += ".icns"
+				}
+				p := fsys.Join(resDir, name)
+				if _, err := fsys.Stat(p); err == nil {
+					return p
+				}
+			}
+		}
+
+		p := fsys.Join(resDir, "AppIcon.icns")
+		if _, err := fsys.Stat(p); err == nil {
+			return p
+		}
+
+		if m, _ := fsys.Glob(fsys.Join(resDir, "*.icns")); len(m) > 0 {
+			return m[0]
+		}
+	}
+
+	return fsys.Join(path, layouts[0][1], "AppIcon.icns")
+}
+
+// 从XML格式的plist中读取key对应的字符串，二进制plist不支持，返回空
+func plistString(d []byte, key string) string {
+	dec := xml.NewDecoder(bytes.NewReader(d))
+	dec.Strict = false
+
+	var found bool
+	for {
+		t, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		var v string
+		switch se.Name.Local {
+		case "key":
+			if err := dec.DecodeElement(&v, &se); err != nil {
+				return ""
+			}
+			found = v == key
+		case "string":
+			if err := dec.DecodeElement(&v, &se); err != nil {
+				return ""
+			}
+			if found {
+				return strings.TrimSpace(v)
+			}
+		default:
+			found = false
+		}
+	}
+}
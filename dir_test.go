@@ -0,0 +1,44 @@
+package fico
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestDirInfo(t *testing.T) {
+	fsys := fstest.MapFS{
+		"empty/readme.txt":                {Data: []byte("readme")},
+		"vol/.VolumeIcon.icns":            {Data: []byte("icns")},
+		"ini/desktop.ini":                 {Data: []byte("[.ShellClassInfo]\r\n")},
+		"A.iconset/icon_16x16.png":        {Data: []byte("png")},
+		"Folder/Icon\r":                   {},
+		"AD/._Icon\r":                     {Data: []byte("appledouble")},
+		"a.app/Contents/Resources/a.icns": {Data: []byte("icns")},
+	}
+
+	tests := []struct {
+		dir, want string
+	}{
+		{"vol", "vol/.VolumeIcon.icns"},
+		{"A.iconset", "A.iconset"},
+		{"Folder", "Folder/Icon\r"},
+		{"AD", "AD/._Icon\r"},
+		{"a.app", "a.app/Contents/Resources/a.icns"},
+		// 没有找到图标时返回ErrNoIcon
+		{"empty", ""},
+		{"ini", ""},
+	}
+	for _, tt := range tests {
+		info, err := GetInfoFS(fsys, tt.dir)
+		if tt.want == "" {
+			if !errors.Is(err, ErrNoIcon) {
+				t.Errorf("%s: got %q %v, want ErrNoIcon", tt.dir, info.IconFile, err)
+			}
+			continue
+		}
+		if err != nil || info.IconFile != tt.want {
+			t.Errorf("%s: got %q %v, want %q", tt.dir, info.IconFile, err, tt.want)
+		}
+	}
+}
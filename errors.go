@@ -0,0 +1,72 @@
+package fico
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// 不支持的文件格式
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// 文件中没有图标
+	ErrNoIcon = errors.New("no icon found")
+	// 指定的图标序号或者资源id不存在
+	ErrIndexOutOfRange = errors.New("icon index out of range")
+	// 数据损坏，具体的格式和偏移见CorruptError
+	ErrCorrupt = errors.New("corrupt data")
+	// 找不到图标时会使用默认图标（或者第一组图标），Config.NoFallback为true时返回该错误
+	ErrFallback = errors.New("fallback to default icon")
+	// 超出Config.Limits中的限制，具体的限制项见LimitError
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// 超出ico格式的限制：Oversize为reject时超过256的条目，或者超过65535个条目
+	ErrOversize = errors.New("exceeds ico format limits")
+)
+
+// CorruptError 数据损坏的位置
+type CorruptError struct {
+	Format string // pe, ico, icns, rsrc, appledouble...
+	Offset int64  // offset of the corrupt data in the file, -1 if unknown
+	Err    error
+}
+
+func (e *CorruptError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("corrupt %s data: %v", e.Format, e.Err)
+	}
+	return fmt.Sprintf("corrupt %s data at offset %d: %v", e.Format, e.Offset, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// errors.Is(err, ErrCorrupt)
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// LimitError 超出的限制
+type LimitError struct {
+	Limit string // MaxPixels, MaxBytes, MaxZipEntries or MaxResources
+	Value int64  // actual value, may be a lower bound when the input is not read completely
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// errors.Is(err, ErrLimitExceeded)
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// 数据损坏的错误
+func corrupt(format string, offset int64, msg string) error {
+	return &CorruptError{Format: format, Offset: offset, Err: errors.New(msg)}
+}
+
+// 不使用默认图标时返回的错误，同时匹配ErrFallback和原因（ErrNoIcon、ErrIndexOutOfRange）
+func fallbackError(reason error) error {
+	return fmt.Errorf("%w: %w", ErrFallback, reason)
+}
@@ -3,16 +3,18 @@ package fico
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"debug/pe"
 	"encoding/binary"
-	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/png"
 	"io"
-	"math"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode/utf16"
@@ -23,83 +25,153 @@ import (
 	_ "image/jpeg"
 
 	"github.com/andrianbdn/iospng"
-	"github.com/appflight/apkparser"
 	_ "github.com/cbeer/jpeg2000"
-	"github.com/tmc/icns"
 	_ "golang.org/x/image/bmp"
-	"golang.org/x/image/draw"
 	_ "golang.org/x/image/tiff"
 )
 
 type Config struct {
-	Format string // png or ico(default)
+	Format string // png, icns or ico(default)
 	Width  int    // 0 for all
 	Height int    // 0 for all
 	Index  *int   // 0 default, nil for all，enabled for PE only
+
+	Appearance string // normal(default), dark or selected, enabled for ICNS only
+	TOC        bool   // write table of contents, enabled for ICNS output only
+
+	Sizes []int // entry sizes for multi-resolution ico/icns output from a single image, e.g. 16, 32, 48, 256
+	BMP   bool  // encode entries smaller than 256 as BMP instead of PNG
+
+	BitCount  int         // bit depth of BMP entries: 32(default), 8 or 4
+	BitCounts map[int]int // bit depth per entry size: 32, 8, 4 for BMP or 0 for PNG, overrides BMP and BitCount
+
+	Oversize string // entries larger than 256 in ico output: downscale(default), reject or keep
+
+	Trim      bool    // trim transparent borders and re-center the content with a uniform margin
+	TrimAlpha uint8   // pixels with alpha not greater than this are treated as transparent when trimming
+	Margin    float64 // safe-area margin on each side as a fraction of the output size when trimming, e.g. 0.0625
+
+	Filter  string // resampling filter: nearest, box, bilinear, catmullrom(default) or lanczos
+	Sharpen bool   // lightly sharpen results downscaled below 32 pixels
+
+	Fit        string      // how to fit Width x Height: contain(default, padded), cover(cropped), stretch or native(unscaled, centered and cropped)
+	Background color.Color // composited under every output image, including re-encoded ico and icns entries, transparent if nil
+
+	NoFallback bool    // return an error wrapping ErrFallback instead of using the default icon or the first group, enabled for PE only
+	Result     *Result // filled with where the icon came from if not nil
+
+	Limits Limits // resource limits against decompression bombs and huge inputs, zero for no limit
+
+	ctx context.Context // set by the *Context variants, checked during zip scans, resource walking, icns decoding and resampling
+}
+
+// Result 图标的来源
+type Result struct {
+	Format   string // handler name of the source, e.g. pe, icns, image
+	Default  bool   // the embedded default icon was used instead of an icon from the file
+	Asset    string // embedded default icon, e.g. assets/GUI.ico
+	Reason   error  // why the default icon or the first group was used, wraps ErrNoIcon or ErrIndexOutOfRange
+	Group    string // name of the RT_GROUP_ICON resource used, enabled for PE only
+	GroupID  int    // id of the RT_GROUP_ICON resource used, 0 for named groups
+	IconID   int    // id of the RT_ICON resource used when Index is a negative resource id
+	Language int    // language id of the resource used
+}
+
+// 记录图标来源，没有配置Result时返回一个临时的结果
+func result(cfg ...Config) *Result {
+	if len(cfg) > 0 && cfg[0].Result != nil {
+		return cfg[0].Result
+	}
+	return &Result{}
 }
 
 func F2ICO(w io.Writer, path string, cfg ...Config) error {
-	ext := strings.ToLower(filepath.Ext(path))
-	switch ext {
-	// https://superuser.com/questions/1480268/icons-no-longer-in-imageres-dll-in-windows-10-1903-4kb-file
-	case ".exe", ".dll", ".mui", ".mun":
-		return PE2ICO(w, path, cfg...)
+	return fs2ICO(w, osFS{}, path, cfg...)
+}
+
+// 读取fs.FS中的文件转换为ico
+func FS2ICO(w io.Writer, fsys fs.FS, path string, cfg ...Config) error {
+	return fs2ICO(w, ioFS{fsys}, path, cfg...)
+}
+
+func fs2ICO(w io.Writer, fsys fileSystem, path string, cfg ...Config) error {
+	// macOS自定义图标：Icon\r 或者 ._Icon\r
+	if isMacIconFile(path) {
+		return macIcon2ICO(w, fsys, path, cfg...)
 	}
 
-	switch ext {
-	case ".ico", ".icns", ".bmp", ".gif", ".jpg", ".jpeg", ".png", ".tiff":
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer f.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".iconset", ".appiconset":
+		return iconset2ICO(w, fsys, path, cfg...)
+	}
 
-		switch ext {
-		case ".ico": // FIXME：如果只需要其中的一种尺寸
-			_, err = io.Copy(w, f)
-			return err
-		case ".icns":
-			return ICNS2ICO(w, f, cfg...)
-		case ".bmp", ".gif", ".jpg", ".jpeg", ".png", ".tiff":
-			return IMG2ICO(w, f, cfg...)
-		}
+	r, size, c, err := openReaderAt(fsys, path, cfg...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
 
-	case ".apk":
-		appInfo, err := apkparser.ParseApk(path)
-		if err != nil {
-			return err
-		}
+	return R2ICO(w, r, size, path, cfg...)
+}
 
-		return img2ICO(w, appInfo.Icon, cfg...)
+// 从io.ReaderAt读取并转换为ico，name用于根据扩展名判断文件格式，
+// 扩展名缺失、未知或者与文件内容不符时，根据文件内容判断
+func R2ICO(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+	h := findHandler(r, size, name, cfg...)
+	if h == nil || h.Convert == nil {
+		return ErrUnsupportedFormat
+	}
 
-	case ".ipa":
-		r, err := zip.OpenReader(path)
-		if err != nil {
-			return err
-		}
-		defer r.Close()
+	*result(cfg...) = Result{Format: h.Name}
+	if len(cfg) > 0 && cfg[0].ctx != nil {
+		r = ctxReaderAt{cfg[0].ctx, r}
+	}
+	return h.Convert(w, r, size, name, cfg...)
+}
 
-		var iosIconFile *zip.File
-		for _, f := range r.File {
-			switch {
-			case strings.Contains(f.Name, "AppIcon"):
-				iosIconFile = f
-			}
-		}
+// ipa中的AppIcon图片转换为ico
+func ipa2ICO(w io.Writer, r *zip.Reader, cfg ...Config) error {
+	d, err := ipaIcon(r, cfg...)
+	if err != nil {
+		return err
+	}
+	return IMG2ICO(w, bytes.NewReader(d), cfg...)
+}
 
-		rc, err := iosIconFile.Open()
-		if err != nil {
-			return err
+// ipa中最后一张AppIcon图片，还原Xcode优化过的PNG（CgBI）
+func ipaIcon(r *zip.Reader, cfg ...Config) ([]byte, error) {
+	l := limitsOf(cfg...)
+	if err := l.checkZip(r); err != nil {
+		return nil, err
+	}
+
+	var iosIconFile *zip.File
+	for _, f := range r.File {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.Contains(f.Name, "AppIcon"):
+			iosIconFile = f
 		}
-		defer rc.Close()
+	}
 
-		var buf bytes.Buffer
-		iospng.PngRevertOptimization(rc, &buf)
+	if iosIconFile == nil {
+		return nil, fmt.Errorf("%w: no AppIcon in ipa", ErrNoIcon)
+	}
+	if err := l.checkZipFile(iosIconFile); err != nil {
+		return nil, err
+	}
 
-		return IMG2ICO(w, bytes.NewReader(buf.Bytes()), cfg...)
+	rc, err := iosIconFile.Open()
+	if err != nil {
+		return nil, err
 	}
+	defer rc.Close()
 
-	return errors.New("conversion failed")
+	var buf bytes.Buffer
+	iospng.PngRevertOptimization(rc, &buf)
+	return buf.Bytes(), nil
 }
 
 type Info struct {
@@ -108,33 +180,79 @@ type Info struct {
 }
 
 func GetInfo(path string) (info Info, err error) {
-	ext := strings.ToLower(filepath.Ext(path))
+	return getInfo(context.Background(), osFS{}, path)
+}
 
-	var f *ini.File
-	switch ext {
-	case ".inf", ".ini", ".desktop":
-		f, err = ini.Load(path)
-		if err != nil {
-			return info, err
-		}
+// 从fs.FS中获取信息，配置文件中引用的图标在同一个文件系统中查找
+func GetInfoFS(fsys fs.FS, path string) (info Info, err error) {
+	return getInfo(context.Background(), ioFS{fsys}, path)
+}
+
+func getInfo(ctx context.Context, fsys fileSystem, path string) (info Info, err error) {
+	// 目录，按优先级探测其中的图标配置
+	if fi, e := fsys.Stat(path); e == nil && fi.IsDir() {
+		return dirInfo(ctx, fsys, path)
+	}
+
+	if isMacIconFile(path) {
+		info.IconFile = path
+		return
+	}
 
-	// *.app目录
-	case ".app":
+	// *.app、*.framework、*.bundle、*.prefPane目录
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".app", ".framework", ".bundle", ".prefpane":
 		/*
 		*.app/Contents/Resources/AppIcon.icns
 		 */
-		info.IconFile = filepath.Join(path, "Contents/Resources/AppIcon.icns")
+		info.IconFile = bundleIcon(fsys, path)
 		return
-	case ".exe", ".dll", ".mui", ".mun", ".ico", ".bmp", ".gif", ".jpg", ".jpeg", ".png", ".tiff", ".icns", ".dmg", ".ipa", ".apk":
+	}
+
+	// 按扩展名和文件内容查找处理器，文件打不开时只按扩展名
+	var r io.ReaderAt
+	var size int64
+	rd, n, c, openErr := openReaderAt(fsys, path)
+	if openErr == nil {
+		defer c.Close()
+		r, size = ctxReaderAt{ctx, rd}, n
+	}
+
+	h := findHandler(r, size, path)
+	if h == nil {
+		// 不支持的格式
+		return info, ErrUnsupportedFormat
+	}
+	if h.Info == nil {
 		// 尝试把iconfile设置为自己
 		info.IconFile = path
 		return
-	default:
-		// 不支持的格式，返回空
-		return
+	}
+	if openErr != nil {
+		return info, openErr
+	}
+
+	info, err = h.Info(r, size, path)
+	if err != nil {
+		return info, err
+	}
+	if info.IconFile == "" {
+		return info, ErrNoIcon
+	}
+
+	// 相对路径相对于配置文件所在目录
+	info.IconFile = resolveIconFile(fsys, fsys.Dir(path), info.IconFile)
+	return
+}
+
+// 解析配置文件中的图标
+func iniInfo(r io.ReaderAt, size int64, name string) (info Info, err error) {
+	f, err := ini.Load(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return info, &CorruptError{Format: "ini", Offset: -1, Err: err}
 	}
 
-	switch ext {
+	switch strings.ToLower(filepath.Ext(name)) {
 	// 配置文件
 	// autorun.inf、desktop.ini、*.desktop(*.AppImage/*.run)
 	case ".inf":
@@ -167,7 +285,7 @@ func GetInfo(path string) (info Info, err error) {
 		*/
 		section, err := f.GetSection("AutoRun")
 		if err != nil {
-			return info, err
+			return info, fmt.Errorf("%w: %v", ErrNoIcon, err)
 		}
 
 		info.IconFile = section.Key("IconFile").MustString(section.Key("DefaultIcon").String())
@@ -189,7 +307,7 @@ func GetInfo(path string) (info Info, err error) {
 		*/
 		section, err := f.GetSection(".ShellClassInfo")
 		if err != nil {
-			return info, err
+			return info, fmt.Errorf("%w: %v", ErrNoIcon, err)
 		}
 
 		info.IconFile = section.Key("IconFile").String()
@@ -209,8 +327,10 @@ func GetInfo(path string) (info Info, err error) {
 				}
 			}
 		}
-	case ".desktop":
+	case ".desktop", ".directory":
 		/*
+			.directory 是 KDE 等桌面环境中的文件夹配置文件，格式与 .desktop 相同。
+
 			创建包含图标和其他资源的 .desktop 文件来为 .AppImage/.run 文件指定图标。然后，您可以将 .AppImage/.run 文件与 .desktop 文件一起分发，并通过 .desktop 文件来启动 .AppImage/.run 文件，并在系统中显示指定的图标。
 
 			以下是一个示例 .desktop 文件的基本结构：
@@ -227,7 +347,7 @@ func GetInfo(path string) (info Info, err error) {
 		*/
 		section, err := f.GetSection("Desktop Entry")
 		if err != nil {
-			return info, err
+			return info, fmt.Errorf("%w: %v", ErrNoIcon, err)
 		}
 
 		info.IconFile = section.Key("Icon").String()
@@ -239,37 +359,48 @@ func GetInfo(path string) (info Info, err error) {
 }
 
 func IMG2ICO(w io.Writer, r io.Reader, cfg ...Config) error {
-	img, _, err := image.Decode(r)
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return err
+	}
+
+	img, err := decodeImage(d, cfg...)
 	if err != nil {
 		return err
 	}
 
-	return img2ICO(w, zoomImg(img, cfg...), cfg...)
+	// 多尺寸输出时从原图缩放
+	if len(cfg) > 0 && len(cfg[0].Sizes) > 0 && cfg[0].Format != "png" {
+		return multiICO(w, img, cfg...)
+	}
+
+	zoomed, err := zoomImg(img, cfg...)
+	if err != nil {
+		return err
+	}
+	return img2ICO(w, zoomed, cfg...)
 }
 
 func img2ICO(w io.Writer, img image.Image, cfg ...Config) (err error) {
-	var buf bytes.Buffer
-	png.Encode(&buf, img)
+	if len(cfg) > 0 && len(cfg[0].Sizes) > 0 && cfg[0].Format != "png" {
+		return multiICO(w, img, cfg...)
+	}
+
+	if len(cfg) > 0 && cfg[0].Format == "icns" {
+		return encodeICNS(w, []image.Image{img}, cfg...)
+	}
 
 	if len(cfg) <= 0 || cfg[0].Format != "png" {
-		err = binary.Write(w, binary.LittleEndian, &ICONDIR{Type: 1, Count: 1})
+		e, err := encodeEntry(img, cfg...)
 		if err != nil {
 			return err
 		}
+		return encodeICO(w, []icoImage{e}, cfg...)
+	}
 
-		err = binary.Write(w, binary.LittleEndian, &ICONDIRENTRY{
-			IconCommon: IconCommon{
-				Width:      uint8(img.Bounds().Dx()),
-				Height:     uint8(img.Bounds().Dy()),
-				Planes:     1,
-				BitCount:   32,
-				BytesInRes: uint32(buf.Len()),
-			},
-			Offset: 0x16,
-		})
-		if err != nil {
-			return err
-		}
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return err
 	}
 
 	_, err = w.Write(buf.Bytes())
@@ -277,8 +408,9 @@ func img2ICO(w io.Writer, img image.Image, cfg ...Config) (err error) {
 }
 
 // https://github.com/nyteshade/ByteRunLengthCoder/blob/main/ByteRunLengthCoder.swift
-func icnsBRLDecode(d []byte) (ret []byte) {
-	for i := 0; i < len(d); {
+// limit大于0时，解码后的数据超过limit字节就不再继续
+func icnsBRLDecode(d []byte, limit int64) (ret []byte) {
+	for i := 0; i < len(d) && (limit <= 0 || int64(len(ret)) <= limit); {
 		b := d[i]
 		if b < 0x80 {
 			cnt := int(b) + 1
@@ -304,6 +436,28 @@ func icnsBRLDecode(d []byte) (ret []byte) {
 	return
 }
 
+// RLE解码后的字节数，与icnsBRLDecode一致，但不生成解码数据
+func icnsBRLSize(d []byte) (n int) {
+	for i := 0; i < len(d); {
+		b := d[i]
+		if b < 0x80 {
+			cnt := int(b) + 1
+			if i+cnt >= len(d) {
+				break
+			}
+			n += cnt
+			i += cnt + 1
+		} else {
+			if i+1 >= len(d) {
+				break
+			}
+			n += int(b) - 0x80 + 3
+			i += 2
+		}
+	}
+	return
+}
+
 func isPNG(d []byte) bool {
 	return len(d) > 8 && string(d[:8]) == "\211PNG\r\n\032\n"
 }
@@ -312,124 +466,49 @@ func isARGB(d []byte) bool {
 	return len(d) > 4 && string(d[:4]) == "ARGB"
 }
 
+// 同尺寸的成员只保留色深最高的，色深相同时优先PNG成员
+func bestICNSEntries(entries []icnsEntry) []icnsEntry {
+	var best []icnsEntry
+	sizes := make(map[[2]int]int)
+	for _, e := range entries {
+		i, ok := sizes[[2]int{e.Width, e.Height}]
+		if !ok {
+			sizes[[2]int{e.Width, e.Height}] = len(best)
+			best = append(best, e)
+			continue
+		}
+		if e.BitCount > best[i].BitCount || (e.BitCount == best[i].BitCount && e.PNG != nil && best[i].PNG == nil) {
+			best[i] = e
+		}
+	}
+	return best
+}
+
 // https://en.wikipedia.org/wiki/Apple_Icon_Image_format
 func ICNS2ICO(w io.Writer, r io.Reader, cfg ...Config) error {
-	iconSet, err := icns.Parse(r)
+	entries, err := icnsEntries(r, cfg...)
 	if err != nil {
 		return err
 	}
 
-	// 掩码映射
-	maskMap := make(map[int]*icns.Icon)
-	var newSet icns.IconSet
-	// 过滤掉无用的OSType
-	for _, icon := range iconSet {
-		switch string(icon.Type[:]) {
-		case "TOC ", "icnV", "name", "info", "sbtp", "slct", "\xFD\xD9\x2F\xA8":
-			continue
-		case "s8mk", "l8mk", "h8mk", "t8mk":
-			maskMap[len(newSet)-1] = icon
-		default:
-			newSet = append(newSet, icon)
-		}
-	}
-
-	var d [][]byte
-	var entries []ICONDIRENTRY
-	offset := 6 + len(newSet)*16
-	for i, icon := range newSet {
-		// it32 data always starts with a header of four zero-bytes
-		// (tested all icns files in macOS 10.15.7 and macOS 11).
-		// Usage unknown, the four zero-bytes can be any value and are quietly ignored.
-		if string(icon.Type[:]) == "it32" && len(icon.Data) >= 4 {
-			icon.Data = icon.Data[4:]
+	var items []icoImage
+	for _, e := range bestICNSEntries(entries) {
+		if err := ctxErr(cfg...); err != nil {
+			return err
 		}
-
-		var w, h, s int
-
-		if isPNG(icon.Data) {
-			d = append(d, icon.Data)
-			img, err := png.DecodeConfig(bytes.NewReader(icon.Data))
-			if err != nil {
+		d, bc := e.PNG, e.BitCount
+		if d == nil {
+			// 重新编码为32位PNG，ICN#、icl4、icl8等旧格式成员的色深不再是1、4、8位
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, e.Image); err != nil {
 				return err
 			}
-			w, h, s = img.Width, img.Height, len(icon.Data)
-		} else {
-			decoded, hasA := false, 1
-			var rgba *image.RGBA
-			switch string(icon.Type[:]) {
-			// 24-bit RGB
-			case "is32", "il32", "ih32", "it32", "icp4", "icp5":
-				if maskData, ok := maskMap[i]; ok {
-					// 构造成ARGB格式
-					newData := append([]byte("ARGB"), maskData.Data...)
-					icon.Data = append(newData, icnsBRLDecode(icon.Data)...)
-				} else {
-					icon.Data = append([]byte("ARGB"), icnsBRLDecode(icon.Data)...)
-					// 说明有没有透明度数据
-					hasA = 0
-				}
-				decoded = true
-			default:
-			}
-
-			if isARGB(icon.Data) {
-				if decoded {
-					icon.Data = icon.Data[4:]
-				} else {
-					icon.Data = icnsBRLDecode(icon.Data[4:])
-				}
-				pixles := len(icon.Data) / 4
-				w := int(math.Sqrt(float64(pixles)))
-				h = w
-
-				rgba = image.NewRGBA(image.Rect(0, 0, w, h))
-				for y := 0; y < h; y++ {
-					for x := 0; x < w; x++ {
-						no := (y*w + x)
-
-						var alpha uint8
-						if hasA > 0 {
-							// 最前面是透明度数据
-							alpha = icon.Data[no]
-						} else {
-							alpha = 0xFF
-						}
-						rgba.Set(x, y, color.RGBA{icon.Data[no+hasA*pixles], icon.Data[no+(1+hasA)*pixles], icon.Data[no+(2+hasA)*pixles], alpha})
-					}
-				}
-			} else {
-				img, _, err := image.Decode(bytes.NewReader(icon.Data))
-				if err != nil {
-					return err
-				}
-
-				rgba = image.NewRGBA(img.Bounds())
-				draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
-			}
-
-			var buf bytes.Buffer
-			png.Encode(&buf, rgba)
-			d = append(d, buf.Bytes())
-
-			w, h, s = rgba.Bounds().Dx(), rgba.Bounds().Dy(), buf.Len()
+			d, bc = buf.Bytes(), 32
 		}
-
-		entries = append(entries, ICONDIRENTRY{
-			IconCommon: IconCommon{
-				Width:      uint8(w),
-				Height:     uint8(h),
-				Planes:     1,
-				BitCount:   32,
-				BytesInRes: uint32(s),
-			},
-			Offset: uint32(offset),
-		})
-
-		offset += s
+		items = append(items, icoImage{Width: e.Width, Height: e.Height, BitCount: bc, Data: d})
 	}
 
-	return writeICO(w, ICONDIR{Type: 1, Count: uint16(len(iconSet))}, entries, d, cfg...)
+	return writeICO(w, items, cfg...)
 }
 
 const (
@@ -443,23 +522,60 @@ const (
 // For example: "3/1/1033" for a resources with ID names, or "10/SOMERES/1033" for a named
 // resource in language 1033.
 type resource struct {
-	Name string
-	Data []byte
+	Name   string
+	Data   []byte
+	Offset int64 // offset of the data in the file
+}
+
+// 资源目录的遍历状态
+type resourceWalker struct {
+	b    []byte
+	addr uint32       // virtual address of the resource section
+	base int64        // offset of the resource section in the file
+	seen map[int]bool // directories on the current path, a directory referencing one of them means a loop
+}
+
+// 解析资源段中的资源目录，越界或者目录成环时返回错误
+func parseDir(b []byte, addr uint32, base int64) ([]*resource, error) {
+	rw := &resourceWalker{b: b, addr: addr, base: base, seen: make(map[int]bool)}
+	return rw.parseDir(0, "", 0)
+}
+
+// 资源数据越界
+func (rw *resourceWalker) corrupt(p int, msg string) error {
+	return corrupt("pe", rw.base+int64(p), msg)
 }
 
 // Recursively parses a IMAGE_RESOURCE_DIRECTORY in slice b starting at position p
 // building on path prefix. virtual is needed to calculate the position of the data
 // in the resource
-func parseDir(b []byte, p int, prefix string, addr uint32) []*resource {
+func (rw *resourceWalker) parseDir(p int, prefix string, depth int) ([]*resource, error) {
 	if prefix != "" && !strings.HasPrefix(prefix, RT_ICON) && !strings.HasPrefix(prefix, RT_GROUP_ICON) {
-		return nil
+		return nil, nil
 	}
 
-	le := binary.LittleEndian
+	// 只有 类型/名称/语言 三层
+	if depth >= 3 {
+		return nil, rw.corrupt(p, "resource directory nested too deep")
+	}
+	// 只标记当前路径上的目录，不同的类型可以共享同一个子目录
+	if rw.seen[p] {
+		return nil, rw.corrupt(p, "resource directory loop")
+	}
+	rw.seen[p] = true
+	defer delete(rw.seen, p)
+
+	b, le := rw.b, binary.LittleEndian
+	if p < 0 || p+16 > len(b) {
+		return nil, rw.corrupt(p, "resource directory out of range")
+	}
 
 	var res []*resource
 	// Skip Characteristics, Timestamp, Major, Minor in the directory
 	n := int(le.Uint16(b[p+12:p+14])) + int(le.Uint16(b[p+14:p+16]))
+	if p+16+8*n > len(b) {
+		return nil, rw.corrupt(p, "resource directory entries out of range")
+	}
 
 	// Iterate over all entries in the current directory record
 	for i := 0; i < n; i++ {
@@ -469,7 +585,13 @@ func parseDir(b []byte, p int, prefix string, addr uint32) []*resource {
 		path := prefix
 		if name&0x80000000 > 0 { // Named entry if the high bit is set in the name
 			dirStr := int(name & 0x7FFFFFFF)
+			if dirStr+2 > len(b) {
+				return nil, rw.corrupt(o, "resource name out of range")
+			}
 			length := int(le.Uint16(b[dirStr : dirStr+2]))
+			if dirStr+2+length<<1 > len(b) {
+				return nil, rw.corrupt(dirStr, "resource name out of range")
+			}
 			resID := make([]uint16, length)
 			binary.Read(bytes.NewReader(b[dirStr+2:dirStr+2+length<<1]), le, resID)
 			path += string(utf16.Decode(resID))
@@ -478,19 +600,30 @@ func parseDir(b []byte, p int, prefix string, addr uint32) []*resource {
 		}
 
 		if offsetToData&0x80000000 > 0 { // Ptr to other directory if high bit is set
+			sub := int(offsetToData & 0x7FFFFFFF)
+			if sub+16 > len(b) {
+				return nil, rw.corrupt(o, "resource directory out of range")
+			}
+
 			// Recursively get the res from the sub dirs
-			l := parseDir(b, int(offsetToData&0x7FFFFFFF), path+"/", addr)
+			l, err := rw.parseDir(sub, path+"/", depth+1)
+			if err != nil {
+				return nil, err
+			}
 			res = append(res, l...)
 			continue
 		}
 
 		// Leaf, ptr to the data entry. Read IMAGE_RESOURCE_DATA_ENTRY
+		if int(offsetToData)+8 > len(b) {
+			return nil, rw.corrupt(o, "resource data entry out of range")
+		}
 		offset := int(le.Uint32(b[offsetToData : offsetToData+4]))
 		length := int(le.Uint32(b[offsetToData+4 : offsetToData+8]))
 
 		// The offset in IMAGE_RESOURCE_DATA_ENTRY is relative to the virual address.
 		// Calculate the address in the file
-		offset -= int(addr)
+		offset -= int(rw.addr)
 
 		// Add boundary checks to prevent panic
 		if offset < 0 || offset+length > len(b) {
@@ -498,9 +631,9 @@ func parseDir(b []byte, p int, prefix string, addr uint32) []*resource {
 		}
 
 		// Add resource to the list
-		res = append(res, &resource{Name: path, Data: b[offset : offset+length]})
+		res = append(res, &resource{Name: path, Data: b[offset : offset+length], Offset: rw.base + int64(offset)})
 	}
-	return res
+	return res, nil
 }
 
 // https://www.cnblogs.com/cswuyg/p/3603707.html
@@ -537,7 +670,66 @@ type ICONDIRENTRY struct {
 	Offset uint32 // 图像数据的偏移量
 }
 
-func defaultICO(w io.Writer, peFile *pe.File, cfg ...Config) error {
+// 解析资源段，返回图标组（RT_GROUP_ICON）和按id索引的图标（RT_ICON）
+func peIcons(rsrc *pe.Section, cfg ...Config) (grpIcons []*resource, idmap map[uint16]*resource, err error) {
+	l := limitsOf(cfg...)
+	if err = l.checkBytes(int64(rsrc.Size)); err != nil {
+		return nil, nil, err
+	}
+
+	// 解析资源表
+	resTable, err := rsrc.Data()
+	if err != nil {
+		return nil, nil, &CorruptError{Format: "pe", Offset: int64(rsrc.Offset), Err: err}
+	}
+
+	resources, err := parseDir(resTable, rsrc.SectionHeader.VirtualAddress, int64(rsrc.Offset))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = l.checkResources(len(resources)); err != nil {
+		return nil, nil, err
+	}
+	idmap = make(map[uint16]*resource)
+	for _, r := range resources {
+		if err = ctxErr(cfg...); err != nil {
+			return nil, nil, err
+		}
+		if strings.HasPrefix(r.Name, RT_GROUP_ICON) {
+			grpIcons = append(grpIcons, r)
+		} else if strings.HasPrefix(r.Name, RT_ICON) {
+			n := strings.Split(r.Name, "/")
+			id, _ := strconv.ParseUint(n[1], 10, 64)
+			idmap[uint16(id)] = r
+		}
+	}
+	return
+}
+
+// 解析图标组数据，数据不足Count个条目时返回错误
+func parseGroup(r *resource) (gid GRPICONDIR, err error) {
+	rd := bytes.NewReader(r.Data)
+	if err = binary.Read(rd, binary.LittleEndian, &gid.ICONDIR); err != nil {
+		return gid, corrupt("pe", r.Offset, "icon group "+r.Name+" truncated")
+	}
+
+	// 每个条目14字节
+	if int(gid.Count)*14 > rd.Len() {
+		return gid, corrupt("pe", r.Offset, "icon group "+r.Name+" truncated")
+	}
+	gid.Entries = make([]RESDIR, gid.Count)
+	for i := uint16(0); i < gid.Count; i++ {
+		binary.Read(rd, binary.LittleEndian, &gid.Entries[i])
+	}
+	return
+}
+
+// 使用默认图标，reason为找不到图标的原因，Config.NoFallback为true时返回错误
+func defaultItems(peFile *pe.File, reason error, cfg ...Config) ([]icoImage, error) {
+	if len(cfg) > 0 && cfg[0].NoFallback {
+		return nil, fallbackError(reason)
+	}
+
 	n := ""
 	if peFile.FileHeader.Characteristics&pe.IMAGE_FILE_DLL != 0 {
 		n = "assets/DLL.ico"
@@ -559,22 +751,16 @@ func defaultICO(w io.Writer, peFile *pe.File, cfg ...Config) error {
 		}
 	}
 
-	iconData, _ := Asset(n)
+	res := result(cfg...)
+	res.Default, res.Asset, res.Reason = true, n, reason
 
-	gid := GRPICONDIR{}
-	rd := bytes.NewReader(iconData)
-	binary.Read(rd, binary.LittleEndian, &gid.ICONDIR)
-	entries := make([]ICONDIRENTRY, gid.Count)
-	for i := uint16(0); i < gid.Count; i++ {
-		binary.Read(rd, binary.LittleEndian, &entries[i])
-	}
+	iconData, _ := Asset(n)
 
-	var d [][]byte
-	for i := uint16(0); i < gid.Count; i++ {
-		d = append(d, iconData[entries[i].Offset:])
+	_, entries, err := parseICO(iconData)
+	if err != nil {
+		return nil, err
 	}
-
-	return writeICO(w, gid.ICONDIR, entries, d, cfg...)
+	return icoImages(entries), nil
 }
 
 /*
@@ -584,263 +770,139 @@ func defaultICO(w io.Writer, peFile *pe.File, cfg ...Config) error {
 Choosing an Icon: https://learn.microsoft.com/en-us/previous-versions/ms997538(v=msdn.10)?redirectedfrom=MSDN#choosing-an-icon
 */
 func PE2ICO(w io.Writer, path string, cfg ...Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
 	// 解析PE文件
-	peFile, err := pe.Open(path)
+	peFile, err := newPEFile(f)
 	if err != nil {
 		return err
 	}
 
-	rsrc := peFile.Section(SECTION_RESOURCES)
-	if rsrc == nil {
-		return defaultICO(w, peFile, cfg...)
+	*result(cfg...) = Result{Format: "pe"}
+	return pe2ICO(w, peFile, cfg...)
+}
+
+// 解析PE文件，格式错误时返回CorruptError
+func newPEFile(r io.ReaderAt) (*pe.File, error) {
+	peFile, err := pe.NewFile(r)
+	if err != nil {
+		return nil, &CorruptError{Format: "pe", Offset: -1, Err: err}
 	}
+	return peFile, nil
+}
 
-	// 解析资源表
-	resTable, err := rsrc.Data()
+func pe2ICO(w io.Writer, peFile *pe.File, cfg ...Config) error {
+	items, byID, err := peItems(peFile, cfg...)
 	if err != nil {
 		return err
 	}
+	// 按id指定的单个图标资源直接转换
+	if byID {
+		return res2ICO(w, items[0].Data, cfg...)
+	}
+	return writeICO(w, items, cfg...)
+}
 
-	resources := parseDir(resTable, 0, "", rsrc.SectionHeader.VirtualAddress)
-	idmap := make(map[uint16]*resource)
-	gid := GRPICONDIR{}
-	var grpIcons []*resource
-	for _, r := range resources {
-		if strings.HasPrefix(r.Name, RT_GROUP_ICON) {
-			grpIcons = append(grpIcons, r)
-		} else if strings.HasPrefix(r.Name, RT_ICON) {
-			n := strings.Split(r.Name, "/")
-			id, _ := strconv.ParseUint(n[1], 10, 64)
-			idmap[uint16(id)] = r
-		}
+// 按Index选择PE中的图标组，找不到时使用默认图标；Index为负数时按id选择单个图标资源，byID为true
+func peItems(peFile *pe.File, cfg ...Config) (items []icoImage, byID bool, err error) {
+	rsrc := peFile.Section(SECTION_RESOURCES)
+	if rsrc == nil {
+		items, err = defaultItems(peFile, fmt.Errorf("%w: no resource section", ErrNoIcon), cfg...)
+		return items, false, err
+	}
+
+	grpIcons, idmap, err := peIcons(rsrc, cfg...)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// 如果没有图标
 	if len(grpIcons) <= 0 {
-		return defaultICO(w, peFile, cfg...)
+		items, err = defaultItems(peFile, fmt.Errorf("%w: no icon group", ErrNoIcon), cfg...)
+		return items, false, err
 	}
 
 	// 获取指定的图标
-	var grpData []byte
+	res := result(cfg...)
+	grp := grpIcons[0]
 	if len(cfg) > 0 {
 		if cfg[0].Index != nil && *cfg[0].Index < 0 {
 			// 如果是负数，那么尝试id
 			if r, ok := idmap[uint16(-*cfg[0].Index)]; ok {
-				return res2ICO(w, r.Data, cfg...)
+				_, res.IconID, res.Language = resName(r)
+				it, err := newICOImage(r.Data, 0)
+				if err != nil {
+					return nil, false, err
+				}
+				return []icoImage{it}, true, nil
 			}
-			return defaultICO(w, peFile, cfg...)
-		}
-		if cfg[0].Index == nil || int(*cfg[0].Index) >= len(grpIcons) {
-			grpData = grpIcons[0].Data
-		} else {
-			grpData = grpIcons[*cfg[0].Index].Data
+			items, err = defaultItems(peFile, fmt.Errorf("%w: no icon with id %d", ErrIndexOutOfRange, -*cfg[0].Index), cfg...)
+			return items, false, err
+		}
+		if cfg[0].Index != nil && int(*cfg[0].Index) >= len(grpIcons) {
+			// 超出范围时使用第一组
+			reason := fmt.Errorf("%w: %d of %d groups", ErrIndexOutOfRange, *cfg[0].Index, len(grpIcons))
+			if cfg[0].NoFallback {
+				return nil, false, fallbackError(reason)
+			}
+			res.Reason = reason
+		} else if cfg[0].Index != nil {
+			grp = grpIcons[*cfg[0].Index]
 		}
-	} else {
-		grpData = grpIcons[0].Data
 	}
+	res.Group, res.GroupID, res.Language = resName(grp)
 
-	rd := bytes.NewReader(grpData)
-	binary.Read(rd, binary.LittleEndian, &gid.ICONDIR)
-	gid.Entries = make([]RESDIR, gid.Count)
-	for i := uint16(0); i < gid.Count; i++ {
-		binary.Read(rd, binary.LittleEndian, &gid.Entries[i])
+	gid, err := parseGroup(grp)
+	if err != nil {
+		return nil, false, err
 	}
 
 	// 如果没有图标
 	if gid.Count <= 0 {
-		return defaultICO(w, peFile, cfg...)
+		items, err = defaultItems(peFile, fmt.Errorf("%w: empty icon group %s", ErrNoIcon, res.Group), cfg...)
+		return items, false, err
 	}
 
-	entries := make([]ICONDIRENTRY, gid.Count)
-	var d [][]byte
-	offset := binary.Size(gid.ICONDIR) + len(entries)*binary.Size(entries[0])
+	var entries []icoDirEntry
 	for i := uint16(0); i < gid.Count; i++ {
 		if r, ok := idmap[gid.Entries[i].ID]; ok {
-			entries[i].IconCommon = gid.Entries[i].IconCommon
-			entries[i].Offset = uint32(offset)
-
-			offset += len(r.Data)
-			d = append(d, r.Data)
+			entries = append(entries, icoDirEntry{ICONDIRENTRY: ICONDIRENTRY{IconCommon: gid.Entries[i].IconCommon}, Data: r.Data})
 		}
 	}
 
-	return writeICO(w, gid.ICONDIR, entries, d, cfg...)
-}
-
-// check 1bit FLAG of x,y coordinator
-func f(d []byte, x, y, w, h int) byte {
-	return d[(w>>3*((h-1)-y))+(x>>3)] >> uint(0x07-(x&0x07)) & 1
+	return icoImages(entries), false, nil
 }
 
-func convert16BitToARGB(value uint16, mask uint32) color.RGBA {
-	return color.RGBA{
-		uint8((uint32(value>>8&0xF8) * (mask >> 16)) >> 8),
-		uint8((uint32(value>>3&0xFC) * (mask >> 8)) >> 8),
-		uint8((uint32(value<<3&0xF8) * mask) >> 8),
-		uint8(mask >> 24),
-	}
-}
-
-func getMaskBit(d []byte, x, y, w, h int) uint32 {
-	if len(d) > 0 && f(d, x, y, w, h) != 0 {
-		return 0
+func res2ICO(w io.Writer, d []byte, cfg ...Config) error {
+	if isPNG(d) {
+		return IMG2ICO(w, bytes.NewReader(d), cfg...)
 	}
-	return 0xFFFFFFFF
-}
 
-// https://stackoverflow.com/questions/16330403/get-hbitmaps-for-all-sizes-and-depths-of-a-file-type-icon-c
-func res2BMP32(d []byte) *image.RGBA {
-	var bmpHdr struct {
-		Size            uint32 // The size of the header (in bytes)
-		Width           int32  // The bitmap's width (in pixels)
-		Height          int32  // The bitmap's height (in pixels)
-		Planes          uint16 // The number of color planes (must be 1)
-		BitCount        uint16 // The number of bits per pixel
-		Compression     uint32 // The compression method being used
-		SizeImage       uint32 // The image size (in bytes)
-		XPelsPerMeter   int32  // The horizontal resolution (pixels per meter)
-		YPelsPerMeter   int32  // The vertical resolution (pixels per meter)
-		ColorsUsed      uint32 // The number of colors in the color palette
-		ColorsImportant uint32 // The number of important colors used
-	}
-	binary.Read(bytes.NewReader(d), binary.LittleEndian, &bmpHdr)
-	w, h, colors := int(bmpHdr.Width), int(bmpHdr.Height), int(bmpHdr.ColorsUsed)
-	var bmp *image.RGBA
-	if h >= w<<1 {
-		bmp = image.NewRGBA(image.Rect(0, 0, w, h>>1))
-	} else {
-		bmp = image.NewRGBA(image.Rect(0, 0, w, h))
-	}
-
-	d = d[40:]
-
-	var bitmask []byte
-	switch bmpHdr.BitCount {
-	case 32: // BGRA
-		if h >= w<<1 {
-			bitmask = d[w*w<<2:]
-			h >>= 1
-		}
-		pixel := 0
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				mask := getMaskBit(bitmask, xx, yy, w, h)
-				bmp.Set(xx, yy, color.RGBA{
-					d[pixel<<2+2] & uint8(mask>>16),
-					d[pixel<<2+1] & uint8(mask>>8),
-					d[pixel<<2] & uint8(mask),
-					d[pixel<<2+3] & uint8(mask>>24),
-				})
-				pixel++
-			}
-		}
-	case 24: // BGR
-		if h == w<<1 {
-			bitmask = d[w*w*3:]
-			h >>= 1
-		}
-		pixel := 0
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				mask := getMaskBit(bitmask, xx, yy, w, h)
-				bmp.Set(xx, yy, color.RGBA{
-					d[pixel*3+2] & uint8(mask>>16),
-					d[pixel*3+1] & uint8(mask>>8),
-					d[pixel*3] & uint8(mask),
-					uint8(mask >> 24),
-				})
-				pixel++
-			}
-		}
-	case 16:
-		if h == w<<1 {
-			bitmask = d[w*w<<1:]
-			h >>= 1
-		}
-		pixel := 0
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				bmp.Set(xx, yy, convert16BitToARGB(
-					binary.LittleEndian.Uint16(d[pixel<<1:]),
-					getMaskBit(bitmask, xx, yy, w, h)))
-				pixel++
-			}
-		}
-	case 8:
-		if colors > 256 || colors <= 0 {
-			colors = 256
-		}
-		if h == w<<1 {
-			bitmask = d[(colors<<2)+(w*w):]
-			h >>= 1
-		}
-		pal := make([]color.RGBA, colors)
-		for i := 0; i < colors; i++ {
-			pal[i] = color.RGBA{d[i<<2+2], d[i<<2+1], d[i<<2], 0xFF} // RGBQUAD BGR
-		}
-		pixel := 0
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				if getMaskBit(bitmask, xx, yy, w, h) != 0 {
-					bmp.Set(xx, yy, pal[d[(colors<<2)+pixel]])
-				}
-				pixel++
-			}
-		}
-	case 4:
-		if colors > 16 || colors <= 0 {
-			colors = 16
-		}
-		if h == w<<1 {
-			bitmask = d[(colors<<2)+(w*w>>1):]
-			h >>= 1
-		}
-		pal := make([]color.RGBA, colors)
-		for i := 0; i < colors; i++ {
-			pal[i] = color.RGBA{d[i<<2+2], d[i<<2+1], d[i<<2], 0xFF} // RGBQUAD BGR
-		}
-		pixel := 0
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				if getMaskBit(bitmask, xx, yy, w, h) != 0 {
-					if pixel&1 > 0 {
-						bmp.Set(xx, yy, pal[d[(colors<<2)+(pixel>>1)]>>4])
-					} else {
-						bmp.Set(xx, yy, pal[d[(colors<<2)+(pixel>>1)]&0x0F])
-					}
-				}
-				pixel++
-			}
-		}
-	case 1:
-		if colors > 2 {
-			colors = 2
-		}
-		if colors <= 0 {
-			colors = 2
-		}
-		pal := make([]color.RGBA, colors)
-		for i := 0; i < colors; i++ {
-			pal[i] = color.RGBA{d[i<<2+2], d[i<<2+1], d[i<<2], 0xFF} // RGBQUAD BGR
-		}
-		retColors := []color.RGBA{pal[0], {0x00, 0xFF, 0x00, 0xFF}, pal[1], {0x00, 0x00, 0xFF, 0xFF}}
-		xorBits, andBits := d[(colors<<2):], d[(colors<<2)+(w*w>>3):]
-		for yy := h - 1; yy > 0; yy-- {
-			for xx := 0; xx < w; xx++ {
-				bmp.Set(xx, yy, retColors[f(xorBits, xx, yy, w, h)<<1|f(andBits, xx, yy, w, h)])
-			}
+	if it, err := newICOImage(d, 0); err == nil {
+		if err = limitsOf(cfg...).checkPixels(it.Width, it.Height); err != nil {
+			return err
 		}
 	}
 
-	return bmp
-}
+	img, err := res2BMP32(d)
+	if err != nil {
+		return err
+	}
 
-func res2ICO(w io.Writer, d []byte, cfg ...Config) error {
-	if isPNG(d) {
-		return IMG2ICO(w, bytes.NewReader(d), cfg...)
+	// 多尺寸输出时从原图缩放
+	if len(cfg) > 0 && len(cfg[0].Sizes) > 0 && cfg[0].Format != "png" {
+		return multiICO(w, img, cfg...)
 	}
 
-	return img2ICO(w, zoomImg(res2BMP32(d), cfg...), cfg...)
+	zoomed, err := zoomImg(img, cfg...)
+	if err != nil {
+		return err
+	}
+	return img2ICO(w, zoomed, cfg...)
 }
 
 func abs(x int) int {
@@ -850,112 +912,112 @@ func abs(x int) int {
 	return x
 }
 
-func writeICO(w io.Writer, id ICONDIR, entries []ICONDIRENTRY, d [][]byte, cfg ...Config) error {
+func writeICO(w io.Writer, items []icoImage, cfg ...Config) error {
+	if len(items) <= 0 {
+		return ErrNoIcon
+	}
+
+	// 条目的尺寸来自PNG或者位图头，解码前检查
+	for _, e := range items {
+		if err := limitsOf(cfg...).checkPixels(e.Width, e.Height); err != nil {
+			return err
+		}
+	}
+
 	// 如果wh设置了，选择合适的单张图标
 	if len(cfg) > 0 && cfg[0].Width > 0 && cfg[0].Height > 0 {
 		var m, wdiff, hdiff, bm int
 		wdiff, hdiff = 0xFFFFF, 0xFFFFF
-		for i, e := range entries {
-			if e.BitCount >= uint16(bm) {
-				bm = int(e.BitCount)
-				var ws, hs int
-				if e.Width <= 0 || e.Height <= 0 { // 超过大小的一定是PNG的
-					img, _, _ := image.DecodeConfig(bytes.NewReader(d[i]))
-					ws, hs = img.Width, img.Height
-				} else {
-					ws, hs = int(e.Width), int(e.Height)
-				}
-				if abs(ws-cfg[0].Width) <= wdiff && abs(hs-cfg[0].Height) <= hdiff {
-					wdiff, hdiff = abs(ws-cfg[0].Width), abs(hs-cfg[0].Height)
+		for i, e := range items {
+			if e.BitCount >= bm {
+				bm = e.BitCount
+				if abs(e.Width-cfg[0].Width) <= wdiff && abs(e.Height-cfg[0].Height) <= hdiff {
+					wdiff, hdiff = abs(e.Width-cfg[0].Width), abs(e.Height-cfg[0].Height)
 					m = i
 				}
 			}
 		}
 
-		return res2ICO(w, d[m], cfg...)
+		return res2ICO(w, items[m].Data, cfg...)
 	}
 
-	// 没有设置，或者不是png格式
-	if len(cfg) <= 0 || cfg[0].Format != "png" {
-		err := binary.Write(w, binary.LittleEndian, id)
-		if err != nil {
-			return err
-		}
+	// icns格式，按色深从高到低排列，同尺寸的只保留色深最高的
+	if len(cfg) > 0 && cfg[0].Format == "icns" {
+		sorted := append([]icoImage(nil), items...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].BitCount > sorted[j].BitCount
+		})
 
-		for _, entry := range entries {
-			err = binary.Write(w, binary.LittleEndian, entry)
+		var imgs []image.Image
+		for _, e := range sorted {
+			if err := ctxErr(cfg...); err != nil {
+				return err
+			}
+			img, err := entryImage(e.Data)
 			if err != nil {
 				return err
 			}
+			if img, err = retouchImg(img, cfg...); err != nil {
+				return err
+			}
+			imgs = append(imgs, img)
 		}
+		return encodeICNS(w, imgs, cfg...)
+	}
 
-		for _, d := range d {
-			_, err = w.Write(d)
-			if err != nil {
+	// 没有设置，或者不是png格式
+	if len(cfg) <= 0 || cfg[0].Format != "png" {
+		if needReencode(cfg...) {
+			var err error
+			if items, err = reencodeItems(items, cfg...); err != nil {
 				return err
 			}
 		}
-		return nil
+		return encodeICO(w, items, cfg...)
 	}
 
 	// 如果是png格式，且wh未设置那么选择色值最多里面像素最大的
 	var m, wm, hm, bm int
-	for i, e := range entries {
-		if e.BitCount >= uint16(bm) {
-			bm = int(e.BitCount)
-			var ws, hs int
-			if e.Width <= 0 || e.Height <= 0 { // 超过大小的一定是PNG的
-				img, _, _ := image.DecodeConfig(bytes.NewReader(d[i]))
-				ws, hs = img.Width, img.Height
-			} else {
-				ws, hs = int(e.Width), int(e.Height)
-			}
-			if ws > wm && hs > hm {
-				wm, hm = ws, hs
+	for i, e := range items {
+		if e.BitCount >= bm {
+			bm = e.BitCount
+			if e.Width > wm && e.Height > hm {
+				wm, hm = e.Width, e.Height
 				m = i
 			}
 		}
 	}
 
-	_, err := w.Write(d[m])
+	// 位图需要先转换为PNG，裁剪透明边缘或者填充背景时需要重新编码
+	if !isPNG(items[m].Data) || cfg[0].Trim || cfg[0].Background != nil {
+		return res2ICO(w, items[m].Data, cfg...)
+	}
+
+	_, err := w.Write(items[m].Data)
 	return err
 }
 
-func zoomImg(srcImg image.Image, cfg ...Config) *image.RGBA {
-	if len(cfg) > 0 && (cfg[0].Width == srcImg.Bounds().Dx() || cfg[0].Height == srcImg.Bounds().Dy()) {
-		switch srcImg := srcImg.(type) {
-		case (*image.RGBA):
-			return srcImg
-		default:
-			rgba := image.NewRGBA(srcImg.Bounds())
-			draw.Draw(rgba, rgba.Bounds(), srcImg, image.Point{0, 0}, draw.Src)
-			return rgba
+func zoomImg(srcImg image.Image, cfg ...Config) (*image.RGBA, error) {
+	if len(cfg) > 0 && cfg[0].Trim {
+		img, err := trimImg(srcImg, cfg[0].Width, cfg[0].Height, cfg[0])
+		if err != nil {
+			return nil, err
 		}
+		return fillBackground(img, cfg...), nil
 	}
 
-	// 计算目标图片的纵横比
-	srcRatio := float64(srcImg.Bounds().Dx()) / float64(srcImg.Bounds().Dy())
-
-	// 计算缩放后的宽度和高度
-	var width, height int
-	if srcRatio > float64(cfg[0].Width)/float64(cfg[0].Height) {
-		width = cfg[0].Width
-		height = int(float64(width) / srcRatio)
-	} else {
-		height = cfg[0].Height
-		width = int(float64(height) * srcRatio)
+	// 未指定尺寸或者尺寸相同时保持原尺寸
+	b := srcImg.Bounds()
+	if len(cfg) <= 0 || cfg[0].Width <= 0 || cfg[0].Height <= 0 ||
+		(cfg[0].Width == b.Dx() && cfg[0].Height == b.Dy()) {
+		return fillBackground(toRGBA(srcImg), cfg...), nil
 	}
 
-	// 计算目标图片的起始位置
-	x := (cfg[0].Width - width) >> 1
-	y := (cfg[0].Height - height) >> 1
-
-	// 使用nearest-neighbor算法缩放图像
-	resizedImg := image.NewRGBA(image.Rect(0, 0, width, height))
-	draw.CatmullRom.Scale(resizedImg, resizedImg.Bounds(), srcImg, srcImg.Bounds(), draw.Over, nil)
-
-	// 将缩放后的图像绘制到目标图片上
+	// 按Fit计算目标区域，再按Filter选择的滤波器缩放
 	img := image.NewRGBA(image.Rect(0, 0, cfg[0].Width, cfg[0].Height))
-	draw.Draw(img, image.Rect(x, y, x+width, y+height), resizedImg, image.Point{0, 0}, draw.Src)
-	return img
+	dr, sr := fitRects(img.Bounds(), b, cfg[0].Fit)
+	if err := resample(img, dr, srcImg, sr, cfg...); err != nil {
+		return nil, err
+	}
+	return fillBackground(img, cfg...), nil
 }
@@ -0,0 +1,121 @@
+package fico
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// 解析失败时必须返回CorruptError
+func checkCorrupt(t *testing.T, err error) {
+	t.Helper()
+	if err != nil && !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+}
+
+// 资源数据必须在资源段之内
+func checkResources(t *testing.T, b []byte, base int64, res []*resource) {
+	t.Helper()
+	for _, r := range res {
+		if r.Offset < base || r.Offset-base+int64(len(r.Data)) > int64(len(b)) {
+			t.Fatalf("resource %s at %d+%d out of the section", r.Name, r.Offset, len(r.Data))
+		}
+	}
+}
+
+func FuzzParseDir(f *testing.F) {
+	f.Add([]byte{}, uint32(0x1000))
+	f.Fuzz(func(t *testing.T, b []byte, addr uint32) {
+		res, err := parseDir(b, addr, 0x400)
+		checkCorrupt(t, err)
+		checkResources(t, b, 0x400, res)
+
+		// 图标组继续按组解析
+		for _, r := range res {
+			if gid, err := parseGroup(r); err == nil && len(gid.Entries) != int(gid.Count) {
+				t.Fatalf("group %s: %d entries, count %d", r.Name, len(gid.Entries), gid.Count)
+			}
+		}
+	})
+}
+
+func FuzzParseGroup(f *testing.F) {
+	f.Add([]byte{0, 0, 1, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, d []byte) {
+		gid, err := parseGroup(&resource{Name: "14/1/1033", Data: d})
+		checkCorrupt(t, err)
+		if err == nil && len(gid.Entries) != int(gid.Count) {
+			t.Fatalf("%d entries, count %d", len(gid.Entries), gid.Count)
+		}
+	})
+}
+
+func FuzzRes2BMP32(f *testing.F) {
+	f.Add(bmpItem(1, 1).Data)
+	f.Fuzz(func(t *testing.T, d []byte) {
+		img, err := res2BMP32(d)
+		checkCorrupt(t, err)
+		if err == nil && img.Bounds().Empty() {
+			t.Fatal("empty image without error")
+		}
+	})
+}
+
+func FuzzParseICO(f *testing.F) {
+	f.Add([]byte{0, 0, 1, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, d []byte) {
+		id, entries, err := parseICO(d)
+		checkCorrupt(t, err)
+		if err != nil {
+			return
+		}
+		if len(entries) != int(id.Count) {
+			t.Fatalf("%d entries, count %d", len(entries), id.Count)
+		}
+
+		// 条目数据同样不能导致panic，只解码较小的条目
+		for _, e := range entries {
+			if it, err := newICOImage(e.Data, int(e.BitCount)); err == nil && it.Width*it.Height <= 1<<16 {
+				entryImage(e.Data)
+			}
+		}
+	})
+}
+
+func FuzzParseICNS(f *testing.F) {
+	f.Add([]byte("icns\x00\x00\x00\x08"))
+	f.Fuzz(func(t *testing.T, d []byte) {
+		if _, err := parseICNS(d, 0, make(icnsOffsets)); err != nil {
+			checkCorrupt(t, err)
+			return
+		}
+
+		// 只列出条目，以及限制尺寸后解码
+		inspectICNS(d, "icns")
+		icnsEntries(bytes.NewReader(d), Config{Limits: Limits{MaxBytes: 1 << 20, MaxPixels: 1 << 16}})
+	})
+}
+
+func FuzzParseResourceFork(f *testing.F) {
+	f.Add(make([]byte, 16))
+	f.Fuzz(func(t *testing.T, d []byte) {
+		res, err := parseResourceFork(d)
+		checkCorrupt(t, err)
+		if err != nil {
+			return
+		}
+		for _, r := range res {
+			if len(r.Data) > len(d) {
+				t.Fatalf("resource %s %d: %d bytes in a %d bytes fork", r.Type, r.ID, len(r.Data), len(d))
+			}
+		}
+
+		if set, err := rsrcIconSet(res); err == nil {
+			var buf bytes.Buffer
+			if err = writeICNS(&buf, set, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,44 @@
+package fico
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// 图标和图标组类型共享同一个名称目录
+func sharedDir() []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 0x64)
+	le.PutUint16(b[14:], 2)
+	le.PutUint32(b[16:], 3)
+	le.PutUint32(b[20:], 0x80000020)
+	le.PutUint32(b[24:], 14)
+	le.PutUint32(b[28:], 0x80000020)
+	le.PutUint16(b[0x20+14:], 1)
+	le.PutUint32(b[0x30:], 1)
+	le.PutUint32(b[0x34:], 0x80000038)
+	le.PutUint16(b[0x38+14:], 1)
+	le.PutUint32(b[0x48:], 1033)
+	le.PutUint32(b[0x4C:], 0x50)
+	le.PutUint32(b[0x50:], 0x1000+0x60)
+	le.PutUint32(b[0x54:], 4)
+	copy(b[0x60:], "data")
+	return b
+}
+
+func TestParseDirShared(t *testing.T) {
+	res, err := parseDir(sharedDir(), 0x1000, 0x400)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"3/1/1033", "14/1/1033"}
+	if len(res) != len(want) {
+		t.Fatalf("got %d resources, want %d", len(res), len(want))
+	}
+	for i, r := range res {
+		if r.Name != want[i] || string(r.Data) != "data" || r.Offset != 0x460 {
+			t.Errorf("resource %d: %s %q at %d", i, r.Name, r.Data, r.Offset)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package fico
+
+import (
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+/*
+按Fit计算把src的sr部分放到area中时的目标区域和源区域：
+
+	contain  等比缩放到完整放入area并居中，四周留空（默认）
+	cover    等比缩放到铺满area，居中裁掉超出的部分
+	stretch  拉伸到area，不保持比例
+	native   保持原尺寸居中，超出area的部分裁掉
+
+150x160的图标放到32x32中，contain为30x32左右各留1像素，cover裁掉上下各5像素后缩放为32x32
+*/
+func fitRects(area, sr image.Rectangle, fit string) (image.Rectangle, image.Rectangle) {
+	aw, ah, sw, sh := area.Dx(), area.Dy(), sr.Dx(), sr.Dy()
+	if area.Empty() || sr.Empty() {
+		return area, sr
+	}
+
+	switch fit {
+	case "stretch":
+		return area, sr
+	case "cover":
+		if sw*ah > sh*aw {
+			cw := max(1, int(math.Round(float64(sh)*float64(aw)/float64(ah))))
+			x := sr.Min.X + (sw-cw)/2
+			return area, image.Rect(x, sr.Min.Y, x+cw, sr.Max.Y)
+		}
+		ch := max(1, int(math.Round(float64(sw)*float64(ah)/float64(aw))))
+		y := sr.Min.Y + (sh-ch)/2
+		return area, image.Rect(sr.Min.X, y, sr.Max.X, y+ch)
+	case "native":
+		w, h := min(sw, aw), min(sh, ah)
+		dx, dy := area.Min.X+(aw-w)/2, area.Min.Y+(ah-h)/2
+		sx, sy := sr.Min.X+(sw-w)/2, sr.Min.Y+(sh-h)/2
+		return image.Rect(dx, dy, dx+w, dy+h), image.Rect(sx, sy, sx+w, sy+h)
+	}
+
+	w, h := aw, ah
+	if sw*ah > sh*aw {
+		h = max(1, int(math.Round(float64(aw)*float64(sh)/float64(sw))))
+	} else {
+		w = max(1, int(math.Round(float64(ah)*float64(sw)/float64(sh))))
+	}
+	x, y := area.Min.X+(aw-w)/2, area.Min.Y+(ah-h)/2
+	return image.Rect(x, y, x+w, y+h), sr
+}
+
+// 转换为RGBA，已经是RGBA的原样返回
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// 不缩放的条目重新编码前，按配置裁剪透明边缘并填充背景
+func retouchImg(img image.Image, cfg ...Config) (image.Image, error) {
+	if len(cfg) <= 0 {
+		return img, nil
+	}
+
+	var err error
+	if cfg[0].Trim {
+		if img, err = trimImg(img, 0, 0, cfg[0]); err != nil {
+			return nil, err
+		}
+	}
+	if cfg[0].Background != nil {
+		img = fillBackground(toRGBA(img), cfg...)
+	}
+	return img, nil
+}
+
+// 合成到Background上，未设置时原样返回
+func fillBackground(img *image.RGBA, cfg ...Config) *image.RGBA {
+	if len(cfg) <= 0 || cfg[0].Background == nil {
+		return img
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(cfg[0].Background), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, dst.Bounds().Min, draw.Over)
+	return dst
+}
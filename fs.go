@@ -0,0 +1,86 @@
+package fico
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// fileSystem 读取图标和配置文件使用的文件系统，本地路径（osFS）或者fs.FS（ioFS）
+type fileSystem interface {
+	Open(name string) (fs.File, error)
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+	Join(elem ...string) string
+	Dir(name string) string
+	IsAbs(name string) bool
+	FromSlash(name string) string
+}
+
+// 本地文件系统，使用操作系统的路径
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Glob(pattern string) ([]string, error)      { return filepath.Glob(pattern) }
+func (osFS) Join(elem ...string) string                 { return filepath.Join(elem...) }
+func (osFS) Dir(name string) string                     { return filepath.Dir(name) }
+func (osFS) IsAbs(name string) bool                     { return filepath.IsAbs(name) }
+func (osFS) FromSlash(name string) string               { return filepath.FromSlash(name) }
+
+// fs.FS文件系统，路径统一使用 / 分隔
+type ioFS struct {
+	fsys fs.FS
+}
+
+func (f ioFS) Open(name string) (fs.File, error)          { return f.fsys.Open(name) }
+func (f ioFS) ReadFile(name string) ([]byte, error)       { return fs.ReadFile(f.fsys, name) }
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(f.fsys, name) }
+func (f ioFS) Stat(name string) (fs.FileInfo, error)      { return fs.Stat(f.fsys, name) }
+func (f ioFS) Glob(pattern string) ([]string, error)      { return fs.Glob(f.fsys, pattern) }
+func (ioFS) Join(elem ...string) string                   { return path.Join(elem...) }
+func (ioFS) Dir(name string) string                       { return path.Dir(name) }
+func (ioFS) IsAbs(name string) bool                       { return path.IsAbs(name) }
+func (ioFS) FromSlash(name string) string                 { return name }
+
+// 读取整个文件，最多读取MaxBytes
+func readFile(fsys fileSystem, name string, cfg ...Config) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readAll(f, cfg...)
+}
+
+// 打开文件用于随机读取，不支持随机读取的文件整个读入内存，最多读取MaxBytes
+func openReaderAt(fsys fileSystem, name string, cfg ...Config) (io.ReaderAt, int64, io.Closer, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+
+	if r, ok := f.(io.ReaderAt); ok {
+		return r, fi.Size(), f, nil
+	}
+
+	d, err := readAll(f, cfg...)
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return bytes.NewReader(d), int64(len(d)), f, nil
+}
@@ -0,0 +1,693 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/cbeer/jpeg2000"
+	"github.com/tmc/icns"
+	"golang.org/x/image/draw"
+)
+
+// 外观变体对应的OSType，其数据是一个嵌套的完整icns
+var icnsAppearances = map[string]string{
+	"dark":     "\xFD\xD9\x2F\xA8",
+	"selected": "slct",
+}
+
+// icns成员在文件中的偏移
+type icnsOffsets map[*icns.Icon]int64
+
+// 成员数据损坏，带上成员在文件中的偏移
+func (o icnsOffsets) corrupt(icon *icns.Icon, err error) error {
+	off, ok := o[icon]
+	if !ok {
+		off = -1
+	}
+	return &CorruptError{Format: "icns", Offset: off, Err: fmt.Errorf("%s: %w", icon.Type[:], err)}
+}
+
+/*
+解析icns，校验文件头和每个成员的长度，base为数据在文件中的偏移：
+
+	Magic  [4]byte // icns
+	Length uint32  // 总长度
+	Icons  []struct{ Type [4]byte; Length uint32; Data []byte } // Length包含8字节的成员头
+*/
+func parseICNS(d []byte, base int64, offsets icnsOffsets) (icns.IconSet, error) {
+	be := binary.BigEndian
+	if len(d) < 8 || string(d[:4]) != "icns" {
+		return nil, corrupt("icns", base, "invalid icns header")
+	}
+
+	l := int64(be.Uint32(d[4:]))
+	if l < 8 || l > int64(len(d)) {
+		return nil, corrupt("icns", base+4, "icns length out of range")
+	}
+
+	var set icns.IconSet
+	for o := int64(8); o+8 <= l; {
+		n := int64(be.Uint32(d[o+4:]))
+		if n < 8 || o+n > l {
+			return nil, corrupt("icns", base+o+4, fmt.Sprintf("%q member length out of range", d[o:o+4]))
+		}
+
+		icon := &icns.Icon{Data: d[o+8 : o+n]}
+		copy(icon.Type[:], d[o:o+4])
+		set = append(set, icon)
+		if offsets != nil {
+			offsets[icon] = base + o
+		}
+		o += n
+	}
+	return set, nil
+}
+
+// 选择指定外观的图标族，不存在或者解析失败时使用普通外观
+func icnsAppearance(set icns.IconSet, appearance string, offsets icnsOffsets) icns.IconSet {
+	t, ok := icnsAppearances[appearance]
+	if !ok {
+		return set
+	}
+
+	for _, icon := range set {
+		if string(icon.Type[:]) != t {
+			continue
+		}
+		if nested, err := parseICNS(icon.Data, offsets[icon]+8, offsets); err == nil && len(nested) > 0 {
+			return nested
+		}
+		break
+	}
+	return set
+}
+
+// icnsEntry icns中的一个图标成员
+type icnsEntry struct {
+	Type     string
+	Width    int
+	Height   int
+	BitCount int
+	Encoding string      // png、rle、argb、palette、mono或jpeg2000
+	PNG      []byte      // PNG成员的原始数据
+	Image    image.Image // 其他成员解码后的图片
+}
+
+// 解析icns并解码其中的图标成员，PNG成员保留原始数据，其他成员解码为图片
+func icnsEntries(r io.Reader, cfg ...Config) ([]icnsEntry, error) {
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(icnsOffsets)
+	iconSet, err := parseICNS(d, 0, offsets)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := limitsOf(cfg...)
+	if err = limits.checkResources(len(iconSet)); err != nil {
+		return nil, err
+	}
+
+	// RLE解码，解码后的大小不超过MaxBytes
+	brlDecode := func(d []byte) ([]byte, error) {
+		ret := icnsBRLDecode(d, limits.MaxBytes)
+		return ret, limits.checkBytes(int64(len(ret)))
+	}
+
+	if len(cfg) > 0 {
+		iconSet = icnsAppearance(iconSet, cfg[0].Appearance, offsets)
+	}
+
+	// 掩码映射
+	maskMap := make(map[int]*icns.Icon)
+	// 经典1位图标（含掩码）映射
+	legacyMasks := make(map[string][]byte)
+	var newSet icns.IconSet
+	// 过滤掉无用的OSType
+	for _, icon := range iconSet {
+		switch string(icon.Type[:]) {
+		case "TOC ", "icnV", "name", "info", "sbtp", "slct", "\xFD\xD9\x2F\xA8":
+			continue
+		case "s8mk", "l8mk", "h8mk", "t8mk":
+			maskMap[len(newSet)-1] = icon
+		case "ICN#", "ics#", "icm#", "ich#":
+			legacyMasks[string(icon.Type[:])] = icon.Data
+			newSet = append(newSet, icon)
+		default:
+			newSet = append(newSet, icon)
+		}
+	}
+
+	var entries []icnsEntry
+	for i, icon := range newSet {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+
+		// it32 data always starts with a header of four zero-bytes
+		// (tested all icns files in macOS 10.15.7 and macOS 11).
+		// Usage unknown, the four zero-bytes can be any value and are quietly ignored.
+		if string(icon.Type[:]) == "it32" && len(icon.Data) >= 4 {
+			icon.Data = icon.Data[4:]
+		}
+
+		e := icnsEntry{Type: string(icon.Type[:]), BitCount: 32}
+		if isPNG(icon.Data) {
+			img, err := png.DecodeConfig(bytes.NewReader(icon.Data))
+			if err != nil {
+				return nil, offsets.corrupt(icon, err)
+			}
+			if err = limits.checkPixels(img.Width, img.Height); err != nil {
+				return nil, err
+			}
+			e.Width, e.Height, e.Encoding, e.PNG = img.Width, img.Height, "png", icon.Data
+		} else {
+			decoded, hasA := false, 1
+			var rgba *image.RGBA
+			switch string(icon.Type[:]) {
+			// 24-bit RGB
+			case "is32", "il32", "ih32", "it32", "icp4", "icp5":
+				rgb, err := brlDecode(icon.Data)
+				if err != nil {
+					return nil, err
+				}
+				if maskData, ok := maskMap[i]; ok {
+					// 构造成ARGB格式
+					newData := append([]byte("ARGB"), maskData.Data...)
+					icon.Data = append(newData, rgb...)
+				} else {
+					icon.Data = append([]byte("ARGB"), rgb...)
+					// 说明有没有透明度数据
+					hasA = 0
+				}
+				decoded = true
+			default:
+			}
+
+			if l, ok := legacyIcons[string(icon.Type[:])]; ok {
+				// 经典的1、4、8位图标
+				rgba, err = l.decode(icon.Data, legacyMasks)
+				if err != nil {
+					return nil, offsets.corrupt(icon, err)
+				}
+				e.BitCount, e.Encoding = l.Depth, "palette"
+				if l.Depth == 1 {
+					e.Encoding = "mono"
+				}
+			} else if isARGB(icon.Data) {
+				if decoded {
+					icon.Data = icon.Data[4:]
+					e.Encoding = "rle"
+				} else {
+					e.Encoding = "argb"
+					if icon.Data, err = brlDecode(icon.Data[4:]); err != nil {
+						return nil, err
+					}
+				}
+				pixles := len(icon.Data) / 4
+				w := int(math.Sqrt(float64(pixles)))
+				h := w
+				if err = limits.checkPixels(w, h); err != nil {
+					return nil, err
+				}
+
+				rgba = image.NewRGBA(image.Rect(0, 0, w, h))
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						no := (y*w + x)
+
+						var alpha uint8
+						if hasA > 0 {
+							// 最前面是透明度数据
+							alpha = icon.Data[no]
+						} else {
+							alpha = 0xFF
+						}
+						rgba.Set(x, y, color.RGBA{icon.Data[no+hasA*pixles], icon.Data[no+(1+hasA)*pixles], icon.Data[no+(2+hasA)*pixles], alpha})
+					}
+				}
+			} else {
+				// 其他成员只能是JPEG 2000，不经过image.Decode，避免嵌套的icns或者ico递归解码
+				img, err := decodeJP2(icon.Data, cfg...)
+				if err != nil {
+					if errors.Is(err, ErrLimitExceeded) || ctxErr(cfg...) != nil {
+						return nil, err
+					}
+					return nil, offsets.corrupt(icon, err)
+				}
+				e.Encoding = "jpeg2000"
+
+				rgba = image.NewRGBA(img.Bounds())
+				draw.Draw(rgba, rgba.Bounds(), img, image.Point{0, 0}, draw.Src)
+			}
+
+			e.Width, e.Height, e.Image = rgba.Bounds().Dx(), rgba.Bounds().Dy(), rgba
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// legacyIcon 经典Mac OS的1、4、8位图标类型
+type legacyIcon struct {
+	Width  int
+	Height int
+	Depth  int    // 色深：1、4、8
+	Mask   string // 同尺寸的1位图标类型，后半部分是掩码
+}
+
+var legacyIcons = map[string]legacyIcon{
+	"ICON": {32, 32, 1, ""},
+	"ICN#": {32, 32, 1, "ICN#"},
+	"icl4": {32, 32, 4, "ICN#"},
+	"icl8": {32, 32, 8, "ICN#"},
+	"ics#": {16, 16, 1, "ics#"},
+	"ics4": {16, 16, 4, "ics#"},
+	"ics8": {16, 16, 8, "ics#"},
+	"icm#": {16, 12, 1, "icm#"},
+	"icm4": {16, 12, 4, "icm#"},
+	"icm8": {16, 12, 8, "icm#"},
+	"ich#": {48, 48, 1, "ich#"},
+	"ich4": {48, 48, 4, "ich#"},
+	"ich8": {48, 48, 8, "ich#"},
+}
+
+// Mac OS标准16色调色板
+var macPalette16 = [16]color.RGBA{
+	{0xFF, 0xFF, 0xFF, 0xFF}, {0xFC, 0xF3, 0x05, 0xFF}, {0xFF, 0x64, 0x02, 0xFF}, {0xDD, 0x08, 0x06, 0xFF},
+	{0xF2, 0x08, 0x84, 0xFF}, {0x46, 0x00, 0xA5, 0xFF}, {0x00, 0x00, 0xD4, 0xFF}, {0x02, 0xAB, 0xEA, 0xFF},
+	{0x1F, 0xB7, 0x14, 0xFF}, {0x00, 0x64, 0x11, 0xFF}, {0x56, 0x2C, 0x05, 0xFF}, {0x90, 0x71, 0x3A, 0xFF},
+	{0xC0, 0xC0, 0xC0, 0xFF}, {0x80, 0x80, 0x80, 0xFF}, {0x40, 0x40, 0x40, 0xFF}, {0x00, 0x00, 0x00, 0xFF},
+}
+
+// Mac OS标准256色调色板：6x6x6的颜色立方体（不含黑色），红、绿、蓝、灰各10级渐变，最后是黑色
+var macPalette256 = func() (pal [256]color.RGBA) {
+	levels := []uint8{0xFF, 0xCC, 0x99, 0x66, 0x33, 0x00}
+	i := 0
+	for _, r := range levels {
+		for _, g := range levels {
+			for _, b := range levels {
+				if i < 215 {
+					pal[i] = color.RGBA{r, g, b, 0xFF}
+					i++
+				}
+			}
+		}
+	}
+
+	ramp := []uint8{0xEE, 0xDD, 0xBB, 0xAA, 0x88, 0x77, 0x55, 0x44, 0x22, 0x11}
+	for c := 0; c < 4; c++ {
+		for _, v := range ramp {
+			switch c {
+			case 0:
+				pal[i] = color.RGBA{v, 0, 0, 0xFF}
+			case 1:
+				pal[i] = color.RGBA{0, v, 0, 0xFF}
+			case 2:
+				pal[i] = color.RGBA{0, 0, v, 0xFF}
+			default:
+				pal[i] = color.RGBA{v, v, v, 0xFF}
+			}
+			i++
+		}
+	}
+	pal[255] = color.RGBA{0, 0, 0, 0xFF}
+	return
+}()
+
+// 解码经典图标，masks为1位图标类型到其数据的映射
+func (l legacyIcon) decode(d []byte, masks map[string][]byte) (*image.RGBA, error) {
+	n := l.Width * l.Height * l.Depth >> 3
+	if len(d) < n {
+		return nil, errors.New("invalid legacy icon data")
+	}
+
+	// 掩码位于1位图标的后半部分，没有掩码则不透明
+	var mask []byte
+	if m, ok := masks[l.Mask]; ok && len(m) >= l.Width*l.Height>>2 {
+		mask = m[l.Width*l.Height>>3:]
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, l.Width, l.Height))
+	for y := 0; y < l.Height; y++ {
+		for x := 0; x < l.Width; x++ {
+			p := y*l.Width + x
+
+			var c color.RGBA
+			switch l.Depth {
+			case 1: // 1为黑色，0为白色
+				c = macPalette16[0]
+				if d[p>>3]>>(7-uint(p&7))&1 != 0 {
+					c = macPalette16[15]
+				}
+			case 4: // 高4位在前
+				c = macPalette16[d[p>>1]>>(4-uint(p&1)<<2)&0x0F]
+			case 8:
+				c = macPalette256[d[p]]
+			}
+
+			if mask != nil && mask[p>>3]>>(7-uint(p&7))&1 == 0 {
+				continue
+			}
+			rgba.SetRGBA(x, y, c)
+		}
+	}
+	return rgba, nil
+}
+
+// 将图标族写成icns文件，toc为true时在最前面写入TOC成员
+func writeICNS(w io.Writer, set icns.IconSet, toc bool) error {
+	if toc {
+		var d bytes.Buffer
+		for _, icon := range set {
+			d.Write(icon.Type[:])
+			binary.Write(&d, binary.BigEndian, uint32(8+len(icon.Data)))
+		}
+		set = append(icns.IconSet{{Type: icns.IconType{'T', 'O', 'C', ' '}, Data: d.Bytes()}}, set...)
+	}
+
+	size := 8
+	for _, icon := range set {
+		size += 8 + len(icon.Data)
+	}
+
+	if _, err := w.Write([]byte("icns")); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(size)); err != nil {
+		return err
+	}
+
+	for _, icon := range set {
+		if _, err := w.Write(icon.Type[:]); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(8+len(icon.Data))); err != nil {
+			return err
+		}
+		if _, err := w.Write(icon.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// icns支持的尺寸，以及对应的PNG成员类型（后面的是@2x的别名）
+var icnsPNGTypes = []struct {
+	Size  int
+	Types []string
+}{
+	{16, []string{"icp4"}},
+	{32, []string{"icp5", "ic11"}},
+	{64, []string{"icp6", "ic12"}},
+	{128, []string{"ic07"}},
+	{256, []string{"ic08", "ic13"}},
+	{512, []string{"ic09", "ic14"}},
+	{1024, []string{"ic10"}},
+}
+
+// 小尺寸额外写入的经典RLE压缩的RGB成员和8位掩码成员
+var icnsRLETypes = map[int][2]string{
+	16: {"is32", "s8mk"},
+	32: {"il32", "l8mk"},
+}
+
+// 最接近的icns尺寸，距离相同时选择大的
+func icnsSize(w, h int) int {
+	m := w
+	if h > m {
+		m = h
+	}
+
+	s := icnsPNGTypes[0].Size
+	for _, t := range icnsPNGTypes {
+		if abs(t.Size-m) <= abs(s-m) {
+			s = t.Size
+		}
+	}
+	return s
+}
+
+// 等比缩放并居中放到s x s的透明画布上
+func squareImg(img image.Image, s int, cfg ...Config) (image.Image, error) {
+	b := img.Bounds()
+	if (b.Dx() == s && b.Dy() == s) || b.Empty() {
+		return img, nil
+	}
+
+	width, height := s, s
+	if b.Dx() > b.Dy() {
+		height = s * b.Dy() / b.Dx()
+	} else {
+		width = s * b.Dx() / b.Dy()
+	}
+
+	x, y := (s-width)>>1, (s-height)>>1
+	rgba := image.NewRGBA(image.Rect(0, 0, s, s))
+	if err := resample(rgba, image.Rect(x, y, x+width, y+height), img, b, cfg...); err != nil {
+		return nil, err
+	}
+	return rgba, nil
+}
+
+// icnsBuilder 逐个添加成员组成icns，同一成员类型只保留最先添加的
+type icnsBuilder struct {
+	set  icns.IconSet
+	used map[string]bool
+	cfg  []Config // 缩放到icns尺寸时使用的Filter和Sharpen
+}
+
+func (b *icnsBuilder) add(t string, d []byte) {
+	if b.used == nil {
+		b.used = make(map[string]bool)
+	}
+	if b.used[t] {
+		return
+	}
+	b.used[t] = true
+
+	icon := &icns.Icon{Data: d}
+	copy(icon.Type[:], t)
+	b.set = append(b.set, icon)
+}
+
+// 按最接近的icns尺寸添加图片，小尺寸附带经典的RGB和掩码成员
+func (b *icnsBuilder) addImage(img image.Image) error {
+	s := icnsSize(img.Bounds().Dx(), img.Bounds().Dy())
+	img, err := squareImg(img, s, b.cfg...)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range icnsPNGTypes {
+		if t.Size != s || b.used[t.Types[0]] {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		for _, typ := range t.Types {
+			b.add(typ, buf.Bytes())
+		}
+	}
+
+	b.addRLE(img)
+	return nil
+}
+
+// 16、32尺寸的图片添加经典的RLE压缩的RGB成员和8位掩码成员
+func (b *icnsBuilder) addRLE(img image.Image) {
+	if t, ok := icnsRLETypes[img.Bounds().Dx()]; ok && img.Bounds().Dx() == img.Bounds().Dy() && !b.used[t[0]] {
+		rgb, mask := icnsRGBMask(img)
+		b.add(t[0], rgb)
+		b.add(t[1], mask)
+	}
+}
+
+// 按尺寸从小到大排列后写出
+func (b *icnsBuilder) write(w io.Writer, toc bool) error {
+	if len(b.set) <= 0 {
+		return ErrNoIcon
+	}
+
+	order := make(map[string]int)
+	for i, t := range icnsPNGTypes {
+		for _, typ := range t.Types {
+			order[typ] = i << 2
+		}
+		if r, ok := icnsRLETypes[t.Size]; ok {
+			order[r[0]], order[r[1]] = i<<2|1, i<<2|2
+		}
+	}
+	sort.SliceStable(b.set, func(i, j int) bool {
+		return order[string(b.set[i].Type[:])] < order[string(b.set[j].Type[:])]
+	})
+
+	return writeICNS(w, b.set, toc)
+}
+
+// 将多张图片编码为icns，同一成员类型只保留最先出现的图片，TOC为true时写入TOC成员
+func encodeICNS(w io.Writer, imgs []image.Image, cfg ...Config) error {
+	b := icnsBuilder{cfg: cfg}
+	for _, img := range imgs {
+		if err := b.addImage(img); err != nil {
+			return err
+		}
+	}
+	return b.write(w, len(cfg) > 0 && cfg[0].TOC)
+}
+
+// 生成经典的RGB成员（R、G、B三个通道分别RLE压缩）和8位掩码成员
+func icnsRGBMask(img image.Image) (rgb, mask []byte) {
+	b := img.Bounds()
+	n := b.Dx() * b.Dy()
+	channels := [3][]byte{make([]byte, n), make([]byte, n), make([]byte, n)}
+	mask = make([]byte, n)
+
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			channels[0][i], channels[1][i], channels[2][i], mask[i] = c.R, c.G, c.B, c.A
+			i++
+		}
+	}
+
+	for _, c := range channels {
+		rgb = append(rgb, icnsBRLEncode(c)...)
+	}
+	return
+}
+
+// icnsBRLDecode的逆过程：3~130个相同字节编码为0x80+(n-3)和该字节，其余按1~128个字节的原样块编码
+func icnsBRLEncode(d []byte) (ret []byte) {
+	for i := 0; i < len(d); {
+		// 相同字节的长度
+		run := 1
+		for i+run < len(d) && run < 130 && d[i+run] == d[i] {
+			run++
+		}
+		if run >= 3 {
+			ret = append(ret, byte(0x80+run-3), d[i])
+			i += run
+			continue
+		}
+
+		// 原样块一直延续到出现3个相同字节为止
+		j := i
+		for j < len(d) && j-i < 128 {
+			if j+2 < len(d) && d[j] == d[j+1] && d[j] == d[j+2] {
+				break
+			}
+			j++
+		}
+		ret = append(ret, byte(j-i-1))
+		ret = append(ret, d[i:j]...)
+		i = j
+	}
+	return
+}
+
+// JPEG 2000的文件头：JP2文件和J2K码流
+var jp2Magics = []string{"\x00\x00\x00\x0cjP  \r\n\x87\n", "\xff\x4f\xff\x51"}
+
+// 是否是JPEG 2000数据
+func isJP2(d []byte) bool {
+	for _, m := range jp2Magics {
+		if bytes.HasPrefix(d, []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+读取JPEG 2000的尺寸，不解码像素数据：
+
+	JP2  盒子 {Length uint32, Type [4]byte}，jp2h中的ihdr为 {Height, Width uint32}
+	J2K  SIZ段 {FF4F FF51, Lsiz, Rsiz uint16, Xsiz, Ysiz, XOsiz, YOsiz uint32}
+*/
+func jp2Size(d []byte) (int, int, error) {
+	be := binary.BigEndian
+	if bytes.HasPrefix(d, []byte(jp2Magics[1])) {
+		if len(d) < 24 {
+			return 0, 0, errors.New("jpeg2000 SIZ segment truncated")
+		}
+		w := int64(be.Uint32(d[8:])) - int64(be.Uint32(d[16:]))
+		h := int64(be.Uint32(d[12:])) - int64(be.Uint32(d[20:]))
+		if w <= 0 || h <= 0 {
+			return 0, 0, errors.New("invalid jpeg2000 size")
+		}
+		return int(w), int(h), nil
+	}
+
+	for o := 0; o+8 <= len(d); {
+		l, t, hdr := int64(be.Uint32(d[o:])), string(d[o+4:o+8]), 8
+		switch l {
+		case 0:
+			l = int64(len(d) - o)
+		case 1:
+			if o+16 > len(d) {
+				return 0, 0, errors.New("jpeg2000 box truncated")
+			}
+			l, hdr = int64(be.Uint64(d[o+8:])), 16
+		}
+		if l < int64(hdr) || int64(o)+l > int64(len(d)) {
+			return 0, 0, errors.New("jpeg2000 box out of range")
+		}
+
+		switch t {
+		case "jp2h":
+			// 超级盒子，进入其中查找ihdr
+			o += hdr
+			continue
+		case "ihdr":
+			if l < int64(hdr)+8 {
+				return 0, 0, errors.New("jpeg2000 ihdr box truncated")
+			}
+			h, w := int(be.Uint32(d[o+hdr:])), int(be.Uint32(d[o+hdr+4:]))
+			if w <= 0 || h <= 0 {
+				return 0, 0, errors.New("invalid jpeg2000 size")
+			}
+			return w, h, nil
+		case "jp2c":
+			return jp2Size(d[o+hdr : int64(o)+l])
+		}
+		o += int(l)
+	}
+	return 0, 0, errors.New("jpeg2000 header not found")
+}
+
+// 解码JPEG 2000成员，直接调用解码器而不经过image.Decode的格式注册，解码前检查尺寸
+func decodeJP2(d []byte, cfg ...Config) (image.Image, error) {
+	if !isJP2(d) {
+		return nil, errors.New("unsupported member data")
+	}
+
+	w, h, err := jp2Size(d)
+	if err != nil {
+		return nil, err
+	}
+	if err = limitsOf(cfg...).checkPixels(w, h); err != nil {
+		return nil, err
+	}
+	if err = ctxErr(cfg...); err != nil {
+		return nil, err
+	}
+	return jpeg2000.Decode(bytes.NewReader(d))
+}
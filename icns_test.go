@@ -0,0 +1,125 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// icns成员
+func icnsMember(t string, d []byte) []byte {
+	b := make([]byte, 8, 8+len(d))
+	copy(b, t)
+	binary.BigEndian.PutUint32(b[4:], uint32(8+len(d)))
+	return append(b, d...)
+}
+
+// 由成员组成的icns文件
+func icnsFile(members ...[]byte) []byte {
+	d := []byte("icns\x00\x00\x00\x00")
+	for _, m := range members {
+		d = append(d, m...)
+	}
+	binary.BigEndian.PutUint32(d[4:], uint32(len(d)))
+	return d
+}
+
+// 每层是只有一个未知成员的icns，成员数据是下一层，最里面是16x16的is32
+func nestedICNS(depth int) []byte {
+	var rle []byte
+	for n := 16 * 16 * 3; n > 0; n -= 128 {
+		rle = append(rle, 0xFD, 0x40)
+	}
+	d := icnsFile(icnsMember("is32", rle))
+	for i := 0; i < depth; i++ {
+		d = icnsFile(icnsMember("XXXX", d))
+	}
+	return d
+}
+
+func TestNestedICNS(t *testing.T) {
+	d := nestedICNS(24)
+	start := time.Now()
+	if _, err := icnsEntries(bytes.NewReader(d)); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+	if _, err := inspectICNS(d, "icns"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("inspect: got %v, want ErrCorrupt", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("nested members took %v", time.Since(start))
+	}
+}
+
+func TestJP2Size(t *testing.T) {
+	be := binary.BigEndian
+	box := func(typ string, d []byte) []byte {
+		b := make([]byte, 8, 8+len(d))
+		be.PutUint32(b, uint32(8+len(d)))
+		copy(b[4:], typ)
+		return append(b, d...)
+	}
+	ihdr := make([]byte, 14)
+	be.PutUint32(ihdr, 48)
+	be.PutUint32(ihdr[4:], 64)
+	siz := make([]byte, 38)
+	copy(siz, "\xff\x4f\xff\x51")
+	be.PutUint32(siz[8:], 40)
+	be.PutUint32(siz[12:], 30)
+	be.PutUint32(siz[16:], 8)
+	be.PutUint32(siz[20:], 0)
+
+	jp2 := append([]byte(jp2Magics[0]), box("ftyp", []byte("jp2 \x00\x00\x00\x00jp2 "))...)
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+		err  bool
+	}{
+		{name: "jp2", data: append(append([]byte{}, jp2...), box("jp2h", box("ihdr", ihdr))...), w: 64, h: 48},
+		{name: "jp2 codestream", data: append(append([]byte{}, jp2...), box("jp2c", siz)...), w: 32, h: 30},
+		{name: "j2k", data: siz, w: 32, h: 30},
+		{name: "truncated ihdr", data: append(append([]byte{}, jp2...), box("jp2h", box("ihdr", ihdr[:4]))...), err: true},
+		{name: "truncated j2k", data: siz[:20], err: true},
+		{name: "no header", data: jp2, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h, err := jp2Size(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil || w != tt.w || h != tt.h {
+				t.Fatalf("got %dx%d %v, want %dx%d", w, h, err, tt.w, tt.h)
+			}
+		})
+	}
+}
+
+func TestICNS2ICOLegacyBitCount(t *testing.T) {
+	// ICN#是32x32的1位图像和掩码，icl8是8位调色板图像，转换后都重新编码为PNG
+	icn := bytes.Repeat([]byte{0xF0}, 256)
+	d := icnsFile(icnsMember("ICN#", icn), icnsMember("ics#", icn[:64]), icnsMember("ics8", make([]byte, 256)))
+
+	var buf bytes.Buffer
+	if err := ICNS2ICO(&buf, bytes.NewReader(d)); err != nil {
+		t.Fatal(err)
+	}
+	_, entries, err := parseICO(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	for i, e := range entries {
+		if !isPNG(e.Data) || e.BitCount != 32 {
+			t.Errorf("entry %d: %dx%d, %d bits, png %v", i, e.Width, e.Height, e.BitCount, isPNG(e.Data))
+		}
+	}
+}
@@ -0,0 +1,403 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"sort"
+)
+
+// icoImage ico中的一个条目
+type icoImage struct {
+	Width    int
+	Height   int
+	BitCount int
+	Data     []byte // PNG或者位图数据
+}
+
+// 生成ico的目录和条目，计算数量和偏移，256及以上的尺寸记为0
+func icoDir(imgs []icoImage) (id ICONDIR, entries []ICONDIRENTRY, d [][]byte) {
+	id = ICONDIR{Type: 1, Count: uint16(len(imgs))}
+	offset := binary.Size(id) + len(imgs)*binary.Size(ICONDIRENTRY{})
+	for _, img := range imgs {
+		var e ICONDIRENTRY
+		if img.Width < 256 {
+			e.Width = uint8(img.Width)
+		}
+		if img.Height < 256 {
+			e.Height = uint8(img.Height)
+		}
+		// 调色板颜色数，256色及以上记为0
+		if img.BitCount < 8 {
+			e.Color = uint8(1 << uint(img.BitCount))
+		}
+		e.Planes, e.BitCount = 1, uint16(img.BitCount)
+		e.BytesInRes, e.Offset = uint32(len(img.Data)), uint32(offset)
+
+		offset += len(img.Data)
+		entries = append(entries, e)
+		d = append(d, img.Data)
+	}
+	return
+}
+
+// 原样写出ico的目录、条目和数据
+func writeICOData(w io.Writer, id ICONDIR, entries []ICONDIRENTRY, d [][]byte) error {
+	err := binary.Write(w, binary.LittleEndian, id)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err = binary.Write(w, binary.LittleEndian, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, d := range d {
+		_, err = w.Write(d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 从一张图片生成多尺寸的ico（或icns），256及以上的条目使用PNG，小尺寸可以选择BMP
+func multiICO(w io.Writer, img image.Image, cfg ...Config) error {
+	var sizes []int
+	used := make(map[int]bool)
+	for _, s := range cfg[0].Sizes {
+		if s > 0 && !used[s] {
+			used[s] = true
+			sizes = append(sizes, s)
+		}
+	}
+	sort.Ints(sizes)
+
+	var imgs []image.Image
+	for _, s := range sizes {
+		if err := ctxErr(cfg...); err != nil {
+			return err
+		}
+		c := cfg[0]
+		c.Width, c.Height = s, s
+		zoomed, err := zoomImg(img, c)
+		if err != nil {
+			return err
+		}
+		imgs = append(imgs, zoomed)
+	}
+
+	if cfg[0].Format == "icns" {
+		return encodeICNS(w, imgs, cfg...)
+	}
+
+	var items []icoImage
+	for _, img := range imgs {
+		e, err := encodeEntry(img, cfg...)
+		if err != nil {
+			return err
+		}
+		items = append(items, e)
+	}
+
+	return encodeICO(w, items, cfg...)
+}
+
+// 条目的编码方式，返回0表示PNG，否则为BMP的色深（32、8、4）
+func entryBitCount(w, h int, cfg ...Config) int {
+	if len(cfg) <= 0 {
+		return 0
+	}
+
+	bc, ok := cfg[0].BitCounts[max(w, h)]
+	if !ok {
+		if !cfg[0].BMP || w >= 256 || h >= 256 {
+			return 0
+		}
+		bc = cfg[0].BitCount
+	}
+
+	switch bc {
+	case 0:
+		if ok {
+			return 0
+		}
+		return 32
+	case 32, 8, 4:
+		return bc
+	}
+	return 32
+}
+
+// 是否需要按配置重新编码条目
+func needReencode(cfg ...Config) bool {
+	return len(cfg) > 0 && (cfg[0].BMP || len(cfg[0].BitCounts) > 0 || cfg[0].Trim || cfg[0].Background != nil)
+}
+
+// 按配置将图片编码为PNG或者BMP条目
+func encodeEntry(img image.Image, cfg ...Config) (icoImage, error) {
+	e := icoImage{Width: img.Bounds().Dx(), Height: img.Bounds().Dy(), BitCount: 32}
+	if bc := entryBitCount(e.Width, e.Height, cfg...); bc > 0 {
+		e.BitCount, e.Data = bc, bmpRes(img, bc)
+		return e, nil
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return e, err
+	}
+	e.Data = buf.Bytes()
+	return e, nil
+}
+
+// 按配置重新编码所有条目
+func reencodeItems(items []icoImage, cfg ...Config) ([]icoImage, error) {
+	var ret []icoImage
+	for _, it := range items {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+		img, err := entryImage(it.Data)
+		if err != nil {
+			return nil, err
+		}
+		if img, err = retouchImg(img, cfg...); err != nil {
+			return nil, err
+		}
+
+		// 只裁剪透明边缘或者填充背景时，位图条目保持原来的编码
+		if !isPNG(it.Data) && !cfg[0].BMP && len(cfg[0].BitCounts) <= 0 {
+			bc := it.BitCount
+			if bc != 8 && bc != 4 {
+				bc = 32
+			}
+			ret = append(ret, icoImage{Width: it.Width, Height: it.Height, BitCount: bc, Data: bmpRes(img, bc)})
+			continue
+		}
+
+		e, err := encodeEntry(img, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// 根据条目数据生成icoImage，尺寸和色深以PNG或者位图头中的为准
+func newICOImage(d []byte, bitCount int) (icoImage, error) {
+	if isPNG(d) {
+		c, err := png.DecodeConfig(bytes.NewReader(d))
+		if err != nil {
+			return icoImage{}, &CorruptError{Format: "png", Offset: -1, Err: err}
+		}
+		if bitCount <= 0 {
+			bitCount = 32
+		}
+		return icoImage{Width: c.Width, Height: c.Height, BitCount: bitCount, Data: d}, nil
+	}
+
+	var hdr BITMAPINFOHEADER
+	if err := binary.Read(bytes.NewReader(d), binary.LittleEndian, &hdr); err != nil {
+		return icoImage{}, corrupt("bmp", -1, "bitmap header truncated")
+	}
+
+	// ico中位图的高度包含了AND掩码
+	w, h := int(hdr.Width), int(hdr.Height)
+	if h < 0 {
+		h = -h
+	}
+	h >>= 1
+	if w <= 0 || h <= 0 {
+		return icoImage{}, corrupt("bmp", -1, "invalid bitmap size")
+	}
+	return icoImage{Width: w, Height: h, BitCount: int(hdr.BitCount), Data: d}, nil
+}
+
+// ico（或cur）目录中的条目及其数据
+type icoDirEntry struct {
+	ICONDIRENTRY
+	HotspotX int // hotspot of the cur entry, Planes and BitCount are zeroed
+	HotspotY int
+	Data     []byte
+}
+
+// 将目录条目转换为icoImage，跳过无法识别的条目
+func icoImages(entries []icoDirEntry) []icoImage {
+	var items []icoImage
+	for _, e := range entries {
+		if it, err := newICOImage(e.Data, int(e.BitCount)); err == nil {
+			items = append(items, it)
+		}
+	}
+	return items
+}
+
+// ico目录中尺寸的上限
+const maxICOSize = 256
+
+/*
+写出ico，数量和偏移根据条目计算，256的尺寸记为0，超过256的条目按配置处理：
+
+	downscale 等比缩小到256以内（默认）
+	reject    返回错误
+	keep      原样保留，尺寸记为0
+*/
+func encodeICO(w io.Writer, items []icoImage, cfg ...Config) error {
+	var oversize string
+	if len(cfg) > 0 {
+		oversize = cfg[0].Oversize
+	}
+
+	// 已有的尺寸，缩小后与之重复的条目不再保留
+	sizes := make(map[[2]int]bool)
+	for _, it := range items {
+		if it.Width <= maxICOSize && it.Height <= maxICOSize {
+			sizes[[2]int{it.Width, it.Height}] = true
+		}
+	}
+
+	var out []icoImage
+	for _, it := range items {
+		if it.Width <= 0 || it.Height <= 0 || len(it.Data) <= 0 {
+			continue
+		}
+
+		if it.Width > maxICOSize || it.Height > maxICOSize {
+			switch oversize {
+			case "keep":
+			case "reject":
+				return fmt.Errorf("%w: entry %dx%d exceeds %d pixels", ErrOversize, it.Width, it.Height, maxICOSize)
+			default:
+				if err := ctxErr(cfg...); err != nil {
+					return err
+				}
+				w, h := fitSize(it.Width, it.Height, maxICOSize)
+				if sizes[[2]int{w, h}] {
+					continue
+				}
+				sizes[[2]int{w, h}] = true
+
+				img, err := entryImage(it.Data)
+				if err != nil {
+					return err
+				}
+
+				if img, err = fitImg(img, maxICOSize, cfg...); err != nil {
+					return err
+				}
+
+				var buf bytes.Buffer
+				if err = png.Encode(&buf, img); err != nil {
+					return err
+				}
+				it = icoImage{Width: w, Height: h, BitCount: 32, Data: buf.Bytes()}
+			}
+		}
+		out = append(out, it)
+	}
+
+	if len(out) <= 0 {
+		return ErrNoIcon
+	}
+	if len(out) > 0xFFFF {
+		return fmt.Errorf("%w: %d entries", ErrOversize, len(out))
+	}
+
+	id, entries, d := icoDir(out)
+	return writeICOData(w, id, entries, d)
+}
+
+// 等比缩放到长边不超过s的尺寸
+func fitSize(w, h, s int) (int, int) {
+	if w <= s && h <= s {
+		return w, h
+	}
+	if w >= h {
+		return s, max(1, h*s/w)
+	}
+	return max(1, w*s/h), s
+}
+
+// 等比缩放到长边不超过s
+func fitImg(img image.Image, s int, cfg ...Config) (image.Image, error) {
+	b := img.Bounds()
+	w, h := fitSize(b.Dx(), b.Dy(), s)
+	if w == b.Dx() && h == b.Dy() {
+		return img, nil
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	if err := resample(rgba, rgba.Bounds(), img, b, cfg...); err != nil {
+		return nil, err
+	}
+	return rgba, nil
+}
+
+// ico（或cur）文件按配置转换，与其他来源一样支持选择尺寸、输出PNG以及转换条目编码
+func ICO2ICO(w io.Writer, r io.Reader, cfg ...Config) error {
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return err
+	}
+
+	_, entries, err := parseICO(d, cfg...)
+	if err != nil {
+		return err
+	}
+	return writeICO(w, icoImages(entries), cfg...)
+}
+
+// 解析ico（或cur）文件，返回目录以及条目和数据，cur中Planes和BitCount是热点坐标，移到HotspotX、HotspotY
+func parseICO(d []byte, cfg ...Config) (id ICONDIR, entries []icoDirEntry, err error) {
+	rd := bytes.NewReader(d)
+	if err = binary.Read(rd, binary.LittleEndian, &id); err != nil {
+		return id, nil, corrupt("ico", 0, "ico header truncated")
+	}
+	if id.Reserved != 0 || (id.Type != 1 && id.Type != 2) {
+		return id, nil, corrupt("ico", 0, "invalid ico header")
+	}
+
+	// 分配目录前检查条目数
+	if err = limitsOf(cfg...).checkResources(int(id.Count)); err != nil {
+		return id, nil, err
+	}
+	if int(id.Count)*binary.Size(ICONDIRENTRY{}) > rd.Len() {
+		return id, nil, corrupt("ico", int64(binary.Size(id)), "ico directory truncated")
+	}
+	dir := make([]ICONDIRENTRY, id.Count)
+	if err = binary.Read(rd, binary.LittleEndian, dir); err != nil {
+		return id, nil, corrupt("ico", int64(binary.Size(id)), "ico directory truncated")
+	}
+
+	entries = make([]icoDirEntry, len(dir))
+	for i, e := range dir {
+		if int64(e.Offset)+int64(e.BytesInRes) > int64(len(d)) {
+			return id, nil, corrupt("ico", int64(binary.Size(id)+i*binary.Size(e)), "ico entry out of range")
+		}
+		entries[i] = icoDirEntry{ICONDIRENTRY: e, Data: d[e.Offset : e.Offset+e.BytesInRes]}
+		if id.Type == 2 {
+			entries[i].HotspotX, entries[i].HotspotY = int(e.Planes), int(e.BitCount)
+			entries[i].Planes, entries[i].BitCount = 0, 0
+		}
+	}
+	return
+}
+
+// 解码ico条目数据，PNG或者位图
+func entryImage(d []byte) (image.Image, error) {
+	if isPNG(d) {
+		img, err := png.Decode(bytes.NewReader(d))
+		if err != nil {
+			return nil, &CorruptError{Format: "png", Offset: -1, Err: err}
+		}
+		return img, nil
+	}
+	return res2BMP32(d)
+}
@@ -0,0 +1,204 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/png"
+	"testing"
+)
+
+// 指定尺寸的PNG条目
+func pngItem(t *testing.T, w, h int) icoImage {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return icoImage{Width: w, Height: h, BitCount: 32, Data: buf.Bytes()}
+}
+
+// 32位BMP条目
+func bmpItem(w, h int) icoImage {
+	return icoImage{Width: w, Height: h, BitCount: 32, Data: bmpRes(image.NewNRGBA(image.Rect(0, 0, w, h)), 32)}
+}
+
+func TestEncodeICORoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		items    []icoImage
+		oversize string
+		want     [][2]int // 解析后条目的尺寸
+		err      bool
+	}{
+		{name: "small", items: []icoImage{bmpItem(16, 16), pngItem(t, 32, 32), bmpItem(48, 48)}, want: [][2]int{{16, 16}, {32, 32}, {48, 48}}},
+		{name: "256", items: []icoImage{pngItem(t, 16, 16), pngItem(t, 256, 256)}, want: [][2]int{{16, 16}, {256, 256}}},
+		{name: "non-square", items: []icoImage{pngItem(t, 150, 160)}, want: [][2]int{{150, 160}}},
+		{name: "512 downscale", items: []icoImage{pngItem(t, 32, 32), pngItem(t, 512, 512)}, want: [][2]int{{32, 32}, {256, 256}}},
+		{name: "1024 downscale", items: []icoImage{pngItem(t, 1024, 512)}, oversize: "downscale", want: [][2]int{{256, 128}}},
+		{name: "downscale duplicate", items: []icoImage{pngItem(t, 256, 256), pngItem(t, 512, 512), pngItem(t, 1024, 1024)}, want: [][2]int{{256, 256}}},
+		{name: "512 reject", items: []icoImage{pngItem(t, 32, 32), pngItem(t, 512, 512)}, oversize: "reject", err: true},
+		{name: "1024 reject", items: []icoImage{pngItem(t, 1024, 1024)}, oversize: "reject", err: true},
+		{name: "512 keep", items: []icoImage{pngItem(t, 32, 32), pngItem(t, 512, 512)}, oversize: "keep", want: [][2]int{{32, 32}, {512, 512}}},
+		{name: "1024 keep", items: []icoImage{pngItem(t, 1024, 1024), pngItem(t, 256, 256)}, oversize: "keep", want: [][2]int{{1024, 1024}, {256, 256}}},
+		{name: "empty", items: []icoImage{{Width: 16, Height: 16}}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := encodeICO(&buf, tt.items, Config{Oversize: tt.oversize})
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			id, entries, err := parseICO(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Type != 1 || int(id.Count) != len(tt.want) || len(entries) != len(tt.want) {
+				t.Fatalf("got type %d count %d, want %d entries", id.Type, id.Count, len(tt.want))
+			}
+
+			// 偏移紧跟在目录之后依次排列，数据与原条目一致
+			offset := uint32(binary.Size(id) + len(entries)*binary.Size(ICONDIRENTRY{}))
+			for i, e := range entries {
+				if e.Offset != offset {
+					t.Errorf("entry %d: offset %d, want %d", i, e.Offset, offset)
+				}
+				offset += e.BytesInRes
+
+				w, h := tt.want[i][0], tt.want[i][1]
+				ew, eh := int(e.Width), int(e.Height)
+				if w >= 256 && ew != 0 || w < 256 && ew != w || h >= 256 && eh != 0 || h < 256 && eh != h {
+					t.Errorf("entry %d: directory size %dx%d, want %dx%d with 256 and larger stored as 0", i, ew, eh, w, h)
+				}
+
+				it, err := newICOImage(e.Data, int(e.BitCount))
+				if err != nil {
+					t.Fatalf("entry %d: %v", i, err)
+				}
+				if it.Width != w || it.Height != h {
+					t.Errorf("entry %d: image size %dx%d, want %dx%d", i, it.Width, it.Height, w, h)
+				}
+				if _, err = entryImage(e.Data); err != nil {
+					t.Errorf("entry %d: %v", i, err)
+				}
+			}
+			if int(offset) != buf.Len() {
+				t.Errorf("file size %d, want %d", buf.Len(), offset)
+			}
+
+			// 保留的条目数据不变
+			if tt.oversize == "keep" {
+				for i, it := range tt.items {
+					if !bytes.Equal(entries[i].Data, it.Data) {
+						t.Errorf("entry %d: data changed", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseICOErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeICO(&buf, []icoImage{bmpItem(16, 16), bmpItem(32, 32)}); err != nil {
+		t.Fatal(err)
+	}
+	d := buf.Bytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"header", d[:4]},
+		{"directory", d[:6+16]},
+		{"data", d[:len(d)-1]},
+		{"type", append([]byte{0, 0, 3, 0}, d[4:]...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseICO(tt.data)
+			if !errors.Is(err, ErrCorrupt) {
+				t.Fatalf("got %v, want ErrCorrupt", err)
+			}
+		})
+	}
+}
+
+func TestParseICOLimits(t *testing.T) {
+	// 条目数超过MaxResources时在分配目录前返回
+	d := []byte{0, 0, 1, 0, 0xFF, 0xFF}
+	_, _, err := parseICO(d, Config{Limits: Limits{MaxResources: 16}})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("got %v, want ErrLimitExceeded", err)
+	}
+
+	// 不限制时目录不完整是损坏
+	if _, _, err = parseICO(d); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("got %v, want ErrCorrupt", err)
+	}
+}
+
+func TestParseCUR(t *testing.T) {
+	var buf bytes.Buffer
+	if err := encodeICO(&buf, []icoImage{bmpItem(16, 16)}); err != nil {
+		t.Fatal(err)
+	}
+	// cur中Planes和BitCount是热点坐标
+	d := buf.Bytes()
+	binary.LittleEndian.PutUint16(d[2:], 2)
+	binary.LittleEndian.PutUint16(d[6+4:], 3)
+	binary.LittleEndian.PutUint16(d[6+6:], 5)
+
+	_, entries, err := parseICO(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.HotspotX != 3 || e.HotspotY != 5 || e.Planes != 0 || e.BitCount != 0 {
+		t.Fatalf("got hotspot %d,%d planes %d bit count %d", e.HotspotX, e.HotspotY, e.Planes, e.BitCount)
+	}
+
+	icons, err := DecodeAllICO(bytes.NewReader(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := icons[0]; i.HotspotX != 3 || i.HotspotY != 5 || i.BitCount != 32 {
+		t.Errorf("decode: got hotspot %d,%d bit count %d", i.HotspotX, i.HotspotY, i.BitCount)
+	}
+
+	sets, err := inspectICO(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := sets[0].Entries[0]; sets[0].Format != "cur" || e.HotspotX != 3 || e.HotspotY != 5 || e.BitCount != 32 {
+		t.Errorf("inspect: got %s hotspot %d,%d bit count %d", sets[0].Format, e.HotspotX, e.HotspotY, e.BitCount)
+	}
+
+	// 转换为ico时不保留热点
+	buf.Reset()
+	if err = ICO2ICO(&buf, bytes.NewReader(d)); err != nil {
+		t.Fatal(err)
+	}
+	if _, entries, err = parseICO(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if e := entries[0]; e.Planes != 1 || e.BitCount != 32 {
+		t.Errorf("ico: got planes %d bit count %d", e.Planes, e.BitCount)
+	}
+}
@@ -0,0 +1,329 @@
+package fico
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// iconsetImage iconset目录中的一张图片
+type iconsetImage struct {
+	Size  int // 逻辑尺寸（pt）
+	Scale int // 1x、2x、3x
+	Data  []byte
+}
+
+// 像素尺寸
+func (i iconsetImage) Pixels() int {
+	return i.Size * i.Scale
+}
+
+/*
+iconset中各图片对应的icns成员类型
+
+	icon_16x16.png       icp4    icon_16x16@2x.png    ic11
+	icon_32x32.png       icp5    icon_32x32@2x.png    ic12
+	icon_128x128.png     ic07    icon_128x128@2x.png  ic13
+	icon_256x256.png     ic08    icon_256x256@2x.png  ic14
+	icon_512x512.png     ic09    icon_512x512@2x.png  ic10
+*/
+var iconsetTypes = map[[2]int]string{
+	{16, 1}: "icp4", {16, 2}: "ic11",
+	{32, 1}: "icp5", {32, 2}: "ic12",
+	{128, 1}: "ic07", {128, 2}: "ic13",
+	{256, 1}: "ic08", {256, 2}: "ic14",
+	{512, 1}: "ic09", {512, 2}: "ic10",
+}
+
+var iconsetName = regexp.MustCompile(`^icon_(\d+)x(\d+)(?:@(\d)x)?\.png$`)
+
+/*
+读取iconset目录，*.iconset按文件名解析，*.appiconset按Contents.json解析：
+
+	{"images": [{"size": "16x16", "idiom": "mac", "filename": "icon_16.png", "scale": "1x"}, ...]}
+*/
+func readIconset(fsys fileSystem, dir string, cfg ...Config) ([]iconsetImage, error) {
+	var imgs []iconsetImage
+	if strings.ToLower(filepath.Ext(dir)) == ".appiconset" {
+		d, err := readFile(fsys, fsys.Join(dir, "Contents.json"), cfg...)
+		if err != nil {
+			return nil, err
+		}
+
+		var contents struct {
+			Images []struct {
+				Size     string `json:"size"`
+				Scale    string `json:"scale"`
+				Filename string `json:"filename"`
+			} `json:"images"`
+		}
+		if err = json.Unmarshal(d, &contents); err != nil {
+			return nil, err
+		}
+
+		for _, i := range contents.Images {
+			// 没有指定文件的是空位
+			if i.Filename == "" {
+				continue
+			}
+
+			// 只能引用目录中的文件，不能是绝对路径或者跳出目录
+			if f := i.Filename; filepath.IsAbs(f) || filepath.VolumeName(f) != "" || strings.ContainsAny(f, `/\`) || strings.Contains(f, "..") {
+				return nil, corrupt("appiconset", -1, fmt.Sprintf("invalid filename %q", f))
+			}
+
+			// 尺寸可能是小数，如 83.5x83.5
+			size, _ := strconv.ParseFloat(strings.SplitN(i.Size, "x", 2)[0], 64)
+			scale, _ := strconv.Atoi(strings.TrimSuffix(i.Scale, "x"))
+			if scale <= 0 {
+				scale = 1
+			}
+
+			data, err := readFile(fsys, fsys.Join(dir, i.Filename), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, iconsetImage{Size: int(math.Round(size)), Scale: scale, Data: data})
+		}
+	} else {
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			m := iconsetName.FindStringSubmatch(e.Name())
+			if m == nil {
+				continue
+			}
+
+			size, _ := strconv.Atoi(m[1])
+			scale := 1
+			if m[3] != "" {
+				scale, _ = strconv.Atoi(m[3])
+			}
+
+			data, err := readFile(fsys, fsys.Join(dir, e.Name()), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			imgs = append(imgs, iconsetImage{Size: size, Scale: scale, Data: data})
+		}
+	}
+
+	if len(imgs) <= 0 {
+		return nil, fmt.Errorf("%w: no image in iconset", ErrNoIcon)
+	}
+
+	// 按像素尺寸从小到大，相同像素尺寸1x在前
+	sort.SliceStable(imgs, func(i, j int) bool {
+		if imgs[i].Pixels() != imgs[j].Pixels() {
+			return imgs[i].Pixels() < imgs[j].Pixels()
+		}
+		return imgs[i].Scale < imgs[j].Scale
+	})
+	return imgs, nil
+}
+
+// iconset目录（*.iconset、*.appiconset）转换为ico，Format为icns时按文件名对应的成员类型输出
+func Iconset2ICO(w io.Writer, dir string, cfg ...Config) error {
+	return iconset2ICO(w, osFS{}, dir, cfg...)
+}
+
+func iconset2ICO(w io.Writer, fsys fileSystem, dir string, cfg ...Config) error {
+	imgs, err := readIconset(fsys, dir, cfg...)
+	if err != nil {
+		return err
+	}
+
+	// 非PNG的图片统一转换为PNG
+	for i := range imgs {
+		if err := ctxErr(cfg...); err != nil {
+			return err
+		}
+		if isPNG(imgs[i].Data) {
+			continue
+		}
+
+		img, err := decodeImage(imgs[i].Data, cfg...)
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err = png.Encode(&buf, img); err != nil {
+			return err
+		}
+		imgs[i].Data = buf.Bytes()
+	}
+
+	if len(cfg) > 0 && cfg[0].Format == "icns" && (cfg[0].Width <= 0 || cfg[0].Height <= 0) {
+		b := icnsBuilder{cfg: cfg}
+		for _, i := range imgs {
+			if err := ctxErr(cfg...); err != nil {
+				return err
+			}
+			img, err := decodeImage(i.Data, cfg...)
+			if err != nil {
+				return err
+			}
+			if cfg[0].Trim || cfg[0].Background != nil {
+				if img, err = retouchImg(img, cfg...); err != nil {
+					return err
+				}
+				var buf bytes.Buffer
+				if err = png.Encode(&buf, img); err != nil {
+					return err
+				}
+				i.Data = buf.Bytes()
+			}
+
+			t, ok := iconsetTypes[[2]int{i.Size, i.Scale}]
+			if !ok || img.Bounds().Dx() != i.Pixels() || img.Bounds().Dy() != i.Pixels() {
+				// 不是标准尺寸的，按最接近的尺寸添加
+				if err = b.addImage(img); err != nil {
+					return err
+				}
+				continue
+			}
+
+			b.add(t, i.Data)
+			if i.Scale == 1 {
+				b.addRLE(img)
+			}
+		}
+		return b.write(w, cfg[0].TOC)
+	}
+
+	// 相同像素尺寸的只保留一张
+	var items []icoImage
+	used := make(map[int]bool)
+	for _, i := range imgs {
+		c, err := png.DecodeConfig(bytes.NewReader(i.Data))
+		if err != nil {
+			return err
+		}
+		if used[c.Width<<16|c.Height] {
+			continue
+		}
+		used[c.Width<<16|c.Height] = true
+
+		items = append(items, icoImage{Width: c.Width, Height: c.Height, BitCount: 32, Data: i.Data})
+	}
+
+	return writeICO(w, items, cfg...)
+}
+
+// iconset导出的尺寸，逻辑尺寸和倍数
+var iconsetSlots = [][2]int{
+	{16, 1}, {16, 2}, {32, 1}, {32, 2}, {128, 1}, {128, 2}, {256, 1}, {256, 2}, {512, 1}, {512, 2},
+}
+
+// 将任意图标来源导出为iconset目录（icon_16x16.png ... icon_512x512@2x.png），
+// 每个尺寸从不小于它的最小图片缩放得到，不会超过原图最大尺寸进行放大
+func F2Iconset(dir string, path string, cfg ...Config) error {
+	// 先转换成包含所有尺寸的ico
+	c := Config{}
+	if len(cfg) > 0 {
+		c = cfg[0]
+	}
+	c.Format, c.Width, c.Height = "ico", 0, 0
+	// 保留超过256的条目，用于生成512、1024的图片
+	c.Oversize = "keep"
+
+	var buf bytes.Buffer
+	if err := F2ICO(&buf, path, c); err != nil {
+		return err
+	}
+
+	_, entries, err := parseICO(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	type source struct {
+		img      image.Image
+		size     int
+		bitCount uint16
+	}
+	var srcs []source
+	for _, e := range entries {
+		img, err := entryImage(e.Data)
+		if err != nil {
+			return err
+		}
+		size := img.Bounds().Dx()
+		if img.Bounds().Dy() > size {
+			size = img.Bounds().Dy()
+		}
+		srcs = append(srcs, source{img, size, e.BitCount})
+	}
+	if len(srcs) <= 0 {
+		return ErrNoIcon
+	}
+
+	// 按尺寸从小到大，相同尺寸色深高的在前
+	sort.SliceStable(srcs, func(i, j int) bool {
+		if srcs[i].size != srcs[j].size {
+			return srcs[i].size < srcs[j].size
+		}
+		return srcs[i].bitCount > srcs[j].bitCount
+	})
+	largest := srcs[len(srcs)-1]
+	for _, s := range srcs {
+		if s.size == largest.size {
+			largest = s
+			break
+		}
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	for _, slot := range iconsetSlots {
+		p := slot[0] * slot[1]
+		if p > largest.size && p > iconsetSlots[0][0] {
+			break
+		}
+
+		src := largest
+		for _, s := range srcs {
+			if s.size >= p {
+				src = s
+				break
+			}
+		}
+
+		name := fmt.Sprintf("icon_%dx%d.png", slot[0], slot[0])
+		if slot[1] > 1 {
+			name = fmt.Sprintf("icon_%dx%d@%dx.png", slot[0], slot[0], slot[1])
+		}
+
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		img, err := squareImg(src.img, p, cfg...)
+		if err == nil {
+			err = png.Encode(f, fillBackground(toRGBA(img), cfg...))
+		}
+		if e := f.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
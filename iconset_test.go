@@ -0,0 +1,42 @@
+package fico
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReadIconsetFilename(t *testing.T) {
+	png := pngItem(t, 16, 16).Data
+	fsys := fstest.MapFS{
+		"secret.png":                  {Data: png},
+		"A.appiconset/sub/icon.png":   {Data: png},
+		"A.appiconset/icon_16x16.png": {Data: png},
+	}
+
+	tests := []struct {
+		filename string
+		err      bool
+	}{
+		{"icon_16x16.png", false},
+		{"../secret.png", true},
+		{"/secret.png", true},
+		{"sub/icon.png", true},
+		{`sub\icon.png`, true},
+		{"..", true},
+	}
+	for _, tt := range tests {
+		fsys["A.appiconset/Contents.json"] = &fstest.MapFile{Data: []byte(`{"images": [{"size": "16x16", "scale": "1x", "filename": ` + strconv.Quote(tt.filename) + `}]}`)}
+		imgs, err := readIconset(ioFS{fsys}, "A.appiconset")
+		if tt.err {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("%q: got %v, want ErrCorrupt", tt.filename, err)
+			}
+			continue
+		}
+		if err != nil || len(imgs) != 1 || imgs[0].Size != 16 {
+			t.Errorf("%q: got %d images %v", tt.filename, len(imgs), err)
+		}
+	}
+}
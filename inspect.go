@@ -0,0 +1,356 @@
+package fico
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/andrianbdn/iospng"
+	"github.com/tmc/icns"
+)
+
+// IconEntry 图标中的一个条目
+type IconEntry struct {
+	Width    int
+	Height   int
+	BitCount int
+	Encoding string // png or bmp for ico/cur/PE; png, rle, argb, palette, mono or jpeg2000 for icns
+	Type     string // OSType of the icns member, e.g. ic08, il32
+	ID       int    // RT_ICON resource id, enabled for PE only
+	Language int    // resource language id, enabled for PE only
+	HotspotX int    // hotspot of the cur entry
+	HotspotY int
+}
+
+// IconSet 一组图标，PE中的一个图标组，或者一个图标文件
+type IconSet struct {
+	Format   string // ico, cur, icns, rsrc, pe, apk, ipa, iconset, appiconset or the image format
+	Name     string // group resource name for PE, file path in the package for apk/ipa
+	ID       int    // RT_GROUP_ICON resource id, 0 for named groups
+	Language int    // resource language id, enabled for PE only
+	Entries  []IconEntry
+}
+
+// icoImage对应的条目信息
+func icoEntry(it icoImage) IconEntry {
+	e := IconEntry{Width: it.Width, Height: it.Height, BitCount: it.BitCount, Encoding: "bmp"}
+	if isPNG(it.Data) {
+		e.Encoding = "png"
+	}
+	return e
+}
+
+// icns成员对应的条目信息
+func (e icnsEntry) entry() IconEntry {
+	return IconEntry{Width: e.Width, Height: e.Height, BitCount: e.BitCount, Encoding: e.Encoding, Type: e.Type}
+}
+
+// 解析资源名 <type>/<name>/<language>
+func resName(r *resource) (name string, id, lang int) {
+	n := strings.Split(r.Name, "/")
+	if len(n) > 1 {
+		name = n[1]
+		id, _ = strconv.Atoi(name)
+	}
+	if len(n) > 2 {
+		lang, _ = strconv.Atoi(n[2])
+	}
+	return
+}
+
+// 列出文件中所有的图标组和条目（尺寸、色深、编码、资源id和语言），不生成图标数据，按Limits限制读取的数据
+func Inspect(path string, cfg ...Config) ([]IconSet, error) {
+	return inspect(osFS{}, path, cfg...)
+}
+
+func inspect(fsys fileSystem, path string, cfg ...Config) ([]IconSet, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".iconset", ".appiconset":
+		imgs, err := readIconset(fsys, path, cfg...)
+		if err != nil {
+			return nil, err
+		}
+
+		set := IconSet{Format: ext[1:]}
+		for _, i := range imgs {
+			e, err := imageEntry(bytes.NewReader(i.Data))
+			if err != nil {
+				return nil, err
+			}
+			set.Entries = append(set.Entries, e)
+		}
+		return []IconSet{set}, nil
+	}
+
+	r, size, c, err := openReaderAt(fsys, path, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	h := findHandler(r, size, path, cfg...)
+	if h == nil || h.Inspect == nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return h.Inspect(r, size, path, cfg...)
+}
+
+// 图标文件的文件头，图片中不再嵌套
+var iconMagics = []string{"icns", "\x00\x00\x01\x00", "\x00\x00\x02\x00"}
+
+// 图片的条目信息，Encoding为图片格式，只读取图片头，不接受嵌套的ico、cur和icns
+func imageEntry(r io.Reader) (IconEntry, error) {
+	br := bufio.NewReader(r)
+	h, _ := br.Peek(4)
+	for _, m := range iconMagics {
+		if string(h) == m {
+			return IconEntry{}, fmt.Errorf("%w: nested icon file", ErrUnsupportedFormat)
+		}
+	}
+
+	c, format, err := image.DecodeConfig(br)
+	if err != nil {
+		return IconEntry{}, imageError(err)
+	}
+	return IconEntry{Width: c.Width, Height: c.Height, BitCount: 32, Encoding: format}, nil
+}
+
+// ico（或cur）文件的条目信息
+func inspectICO(d []byte, cfg ...Config) ([]IconSet, error) {
+	id, entries, err := parseICO(d, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	set := IconSet{Format: "ico"}
+	if id.Type == 2 {
+		set.Format = "cur"
+	}
+	for _, e := range entries {
+		it, err := newICOImage(e.Data, int(e.BitCount))
+		if err != nil {
+			continue
+		}
+
+		ie := icoEntry(it)
+		ie.HotspotX, ie.HotspotY = e.HotspotX, e.HotspotY
+		set.Entries = append(set.Entries, ie)
+	}
+	return []IconSet{set}, nil
+}
+
+// PE文件中的所有图标组，条目的尺寸和色深以图标数据中的为准
+func inspectPE(r io.ReaderAt, cfg ...Config) ([]IconSet, error) {
+	peFile, err := newPEFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	rsrc := peFile.Section(SECTION_RESOURCES)
+	if rsrc == nil {
+		return nil, nil
+	}
+
+	grpIcons, idmap, err := peIcons(rsrc, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	var sets []IconSet
+	for _, g := range grpIcons {
+		set := IconSet{Format: "pe"}
+		set.Name, set.ID, set.Language = resName(g)
+
+		gid, err := parseGroup(g)
+		if err != nil {
+			return nil, err
+		}
+		for _, re := range gid.Entries {
+			r, ok := idmap[re.ID]
+			if !ok {
+				continue
+			}
+
+			it, err := newICOImage(r.Data, int(re.BitCount))
+			if err != nil {
+				continue
+			}
+
+			e := icoEntry(it)
+			_, e.ID, e.Language = resName(r)
+			set.Entries = append(set.Entries, e)
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// ipa中所有的AppIcon图片
+func inspectIPA(r *zip.Reader, cfg ...Config) ([]IconSet, error) {
+	l := limitsOf(cfg...)
+	if err := l.checkZip(r); err != nil {
+		return nil, err
+	}
+
+	var sets []IconSet
+	for _, f := range r.File {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+		if !strings.Contains(f.Name, "AppIcon") {
+			continue
+		}
+		if err := l.checkZipFile(f); err != nil {
+			return nil, err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		// 还原Xcode优化过的PNG（CgBI）
+		var buf bytes.Buffer
+		iospng.PngRevertOptimization(rc, &buf)
+		rc.Close()
+
+		c, err := png.DecodeConfig(&buf)
+		if err != nil {
+			continue
+		}
+
+		sets = append(sets, IconSet{Format: "ipa", Name: f.Name,
+			Entries: []IconEntry{{Width: c.Width, Height: c.Height, BitCount: 32, Encoding: "png"}}})
+	}
+
+	if len(sets) <= 0 {
+		return nil, fmt.Errorf("%w: no AppIcon in ipa", ErrNoIcon)
+	}
+	return sets, nil
+}
+
+// RLE压缩的RGB成员和ARGB成员的尺寸
+var icnsMemberSizes = map[string]int{
+	"is32": 16, "il32": 32, "ih32": 48, "it32": 128, "icp4": 16, "icp5": 32,
+	"ic04": 16, "ic05": 32, "icsb": 18, "icsB": 36, "sb24": 24, "SB24": 48,
+}
+
+// RLE压缩的24位RGB成员
+var icnsRGBTypes = map[string]bool{"is32": true, "il32": true, "ih32": true, "it32": true, "icp4": true, "icp5": true}
+
+/*
+icns中各成员的条目信息，只读取PNG和JPEG 2000的头，RLE、ARGB和经典图标的尺寸按成员类型得到，不解码像素数据。
+深色、选中外观各自列为一组，Name为dark、selected
+*/
+func inspectICNS(d []byte, format string, cfg ...Config) ([]IconSet, error) {
+	offsets := make(icnsOffsets)
+	set, err := parseICNS(d, 0, offsets)
+	if err != nil {
+		return nil, err
+	}
+	if err = limitsOf(cfg...).checkResources(len(set)); err != nil {
+		return nil, err
+	}
+
+	entries, err := icnsMemberEntries(set, offsets)
+	if err != nil {
+		return nil, err
+	}
+	sets := []IconSet{{Format: format, Entries: entries}}
+
+	for _, name := range []string{"dark", "selected"} {
+		for _, icon := range set {
+			if string(icon.Type[:]) != icnsAppearances[name] {
+				continue
+			}
+
+			nested, err := parseICNS(icon.Data, offsets[icon]+8, offsets)
+			if err != nil {
+				return nil, err
+			}
+			entries, err := icnsMemberEntries(nested, offsets)
+			if err != nil {
+				return nil, err
+			}
+			sets = append(sets, IconSet{Format: format, Name: name, Entries: entries})
+			break
+		}
+	}
+	return sets, nil
+}
+
+// icns成员的条目信息，跳过掩码和TOC等非图标成员
+func icnsMemberEntries(set icns.IconSet, offsets icnsOffsets) ([]IconEntry, error) {
+	var entries []IconEntry
+	for _, icon := range set {
+		t := string(icon.Type[:])
+		switch t {
+		case "TOC ", "icnV", "name", "info", "sbtp", "slct", "\xFD\xD9\x2F\xA8",
+			"s8mk", "l8mk", "h8mk", "t8mk":
+			continue
+		}
+
+		e := IconEntry{Type: t, BitCount: 32}
+		if l, ok := legacyIcons[t]; ok {
+			e.Width, e.Height, e.BitCount, e.Encoding = l.Width, l.Height, l.Depth, "palette"
+			if l.Depth == 1 {
+				e.Encoding = "mono"
+			}
+		} else if isPNG(icon.Data) {
+			c, err := png.DecodeConfig(bytes.NewReader(icon.Data))
+			if err != nil {
+				return nil, offsets.corrupt(icon, err)
+			}
+			e.Width, e.Height, e.Encoding = c.Width, c.Height, "png"
+		} else if s, ok := icnsMemberSizes[t]; ok && (isARGB(icon.Data) || icnsRGBTypes[t]) {
+			e.Width, e.Height, e.Encoding = s, s, "rle"
+			if !icnsRGBTypes[t] {
+				e.Encoding = "argb"
+			}
+		} else if isARGB(icon.Data) {
+			// 未知类型的ARGB成员按解压后的像素数得到尺寸，只计算长度不解压
+			w := int(math.Sqrt(float64(icnsBRLSize(icon.Data[4:]) / 4)))
+			e.Width, e.Height, e.Encoding = w, w, "argb"
+		} else if isJP2(icon.Data) {
+			w, h, err := jp2Size(icon.Data)
+			if err != nil {
+				return nil, offsets.corrupt(icon, err)
+			}
+			e.Width, e.Height, e.Encoding = w, h, "jpeg2000"
+		} else {
+			return nil, offsets.corrupt(icon, errors.New("unsupported member data"))
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// 资源分支中的图标族
+func inspectRSRC(d []byte, cfg ...Config) ([]IconSet, error) {
+	res, err := parseResourceFork(d)
+	if err != nil {
+		return nil, err
+	}
+	if err = limitsOf(cfg...).checkResources(len(res)); err != nil {
+		return nil, err
+	}
+	set, err := rsrcIconSet(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = writeICNS(&buf, set, false); err != nil {
+		return nil, err
+	}
+	return inspectICNS(buf.Bytes(), "rsrc", cfg...)
+}
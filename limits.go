@@ -0,0 +1,174 @@
+package fico
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/appflight/apkparser"
+)
+
+// Limits 限制输入的大小，防止解压炸弹和超大文件耗尽内存，0表示不限制
+type Limits struct {
+	MaxPixels     int64 // max width*height of an image, checked with DecodeConfig before decoding
+	MaxBytes      int64 // max bytes of an input read into memory, a decompressed zip entry, RLE data or a resource section
+	MaxZipEntries int   // max entries in apk, ipa and zip
+	MaxResources  int   // max PE resources, resource fork resources or icns members
+}
+
+func limitsOf(cfg ...Config) Limits {
+	if len(cfg) > 0 {
+		return cfg[0].Limits
+	}
+	return Limits{}
+}
+
+// 检查图片的像素数
+func (l Limits) checkPixels(w, h int) error {
+	if l.MaxPixels > 0 && int64(w)*int64(h) > l.MaxPixels {
+		return &LimitError{Limit: "MaxPixels", Value: int64(w) * int64(h), Max: l.MaxPixels}
+	}
+	return nil
+}
+
+// 检查读入内存或者解压后的字节数
+func (l Limits) checkBytes(n int64) error {
+	if l.MaxBytes > 0 && n > l.MaxBytes {
+		return &LimitError{Limit: "MaxBytes", Value: n, Max: l.MaxBytes}
+	}
+	return nil
+}
+
+// 检查资源数量
+func (l Limits) checkResources(n int) error {
+	if l.MaxResources > 0 && n > l.MaxResources {
+		return &LimitError{Limit: "MaxResources", Value: int64(n), Max: int64(l.MaxResources)}
+	}
+	return nil
+}
+
+// 检查zip的条目数量
+func (l Limits) checkZip(zr *zip.Reader) error {
+	if l.MaxZipEntries > 0 && len(zr.File) > l.MaxZipEntries {
+		return &LimitError{Limit: "MaxZipEntries", Value: int64(len(zr.File)), Max: int64(l.MaxZipEntries)}
+	}
+	return nil
+}
+
+// 检查zip条目解压后的大小，archive/zip读取超过头中记录的大小时会返回错误，所以头中的大小可信
+func (l Limits) checkZipFile(f *zip.File) error {
+	if l.MaxBytes > 0 && f.UncompressedSize64 > uint64(l.MaxBytes) {
+		return &LimitError{Limit: "MaxBytes", Value: int64(min(f.UncompressedSize64, 1<<63-1)), Max: l.MaxBytes}
+	}
+	return nil
+}
+
+// 读入全部数据，最多读取MaxBytes+1个字节
+func readAll(r io.Reader, cfg ...Config) ([]byte, error) {
+	l := limitsOf(cfg...)
+	if l.MaxBytes <= 0 {
+		return io.ReadAll(r)
+	}
+
+	d, err := io.ReadAll(io.LimitReader(r, l.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if err = l.checkBytes(int64(len(d))); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// 先用DecodeConfig检查尺寸再解码图片
+func decodeImage(d []byte, cfg ...Config) (image.Image, error) {
+	l := limitsOf(cfg...)
+	if l.MaxPixels > 0 {
+		c, _, err := image.DecodeConfig(bytes.NewReader(d))
+		if err != nil {
+			return nil, imageError(err)
+		}
+		if err = l.checkPixels(c.Width, c.Height); err != nil {
+			return nil, err
+		}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(d))
+	if err != nil {
+		return nil, imageError(err)
+	}
+	return img, nil
+}
+
+// 无法识别的图片格式返回ErrUnsupportedFormat，解码失败返回CorruptError
+func imageError(err error) error {
+	if errors.Is(err, image.ErrFormat) {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	return &CorruptError{Format: "image", Offset: -1, Err: err}
+}
+
+// apk的条目数量和apkparser读取的清单、资源表的大小，启动图标在解析出路径后单独检查
+func checkApk(zr *apkparser.ZipReader, cfg ...Config) error {
+	l := limitsOf(cfg...)
+	if l.MaxZipEntries > 0 && len(zr.FilesOrdered) > l.MaxZipEntries {
+		return &LimitError{Limit: "MaxZipEntries", Value: int64(len(zr.FilesOrdered)), Max: int64(l.MaxZipEntries)}
+	}
+
+	for _, f := range zr.FilesOrdered {
+		// 头损坏的条目没有大小信息
+		h := f.ZipHeader()
+		if h == nil {
+			continue
+		}
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".xml", ".arsc":
+			if l.MaxBytes > 0 && h.UncompressedSize64 > uint64(l.MaxBytes) {
+				return &LimitError{Limit: "MaxBytes", Value: int64(min(h.UncompressedSize64, 1<<63-1)), Max: l.MaxBytes}
+			}
+		}
+	}
+	return nil
+}
+
+/*
+解析中央目录前，按目录结束记录中的条目数检查MaxZipEntries：
+
+	EOCD   {Signature PK\x05\x06, Disk, DirDisk, DiskEntries, Entries uint16, ...} // 在文件末尾，后面最多65535字节的注释
+	Zip64  Entries为0xFFFF时，EOCD前的定位记录（PK\x06\x07）指向zip64的目录结束记录，其中Entries为uint64
+*/
+func (l Limits) checkZipDir(r io.ReaderAt, size int64) error {
+	if l.MaxZipEntries <= 0 || size < 22 {
+		return nil
+	}
+
+	tail := make([]byte, min(size, 22+0xFFFF))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil && err != io.EOF {
+		return nil
+	}
+	i := bytes.LastIndex(tail, []byte("PK\x05\x06"))
+	if i < 0 || i+22 > len(tail) {
+		// 交给archive/zip报告格式错误
+		return nil
+	}
+
+	le := binary.LittleEndian
+	n := uint64(le.Uint16(tail[i+10:]))
+	if n == 0xFFFF && i >= 20 && string(tail[i-20:i-16]) == "PK\x06\x07" {
+		var eocd64 [40]byte
+		if _, err := r.ReadAt(eocd64[:], int64(le.Uint64(tail[i-12:]))); err == nil && string(eocd64[:4]) == "PK\x06\x06" {
+			n = le.Uint64(eocd64[32:])
+		}
+	}
+
+	if n > uint64(l.MaxZipEntries) {
+		return &LimitError{Limit: "MaxZipEntries", Value: int64(min(n, 1<<63-1)), Max: int64(l.MaxZipEntries)}
+	}
+	return nil
+}
@@ -0,0 +1,419 @@
+package fico
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Handler 文件格式的处理器，按扩展名和（或）文件内容匹配
+type Handler struct {
+	Name     string
+	Exts     []string // lower case extensions with the dot, e.g. .exe, .dll
+	Magic    []string // magic bytes at the beginning of the file
+	Priority int      // higher priority handlers are tried first, later registered ones win on ties
+
+	// content matcher for formats that magic bytes cannot tell, e.g. zip based packages
+	Match func(r io.ReaderAt, size int64) bool
+
+	// convert to ico, nil if the format has no icon itself
+	Convert func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error
+
+	// extract and decode the icon entries without encoding them to ico, nil if not supported
+	Load func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error)
+
+	// read the icon configuration, nil if the file is the icon file itself
+	Info func(r io.ReaderAt, size int64, name string) (Info, error)
+
+	// list the icon groups and entries without decoding pixels, nil if not supported
+	Inspect func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error)
+}
+
+// 是否有内容匹配规则
+func (h *Handler) hasContent() bool {
+	return len(h.Magic) > 0 || h.Match != nil
+}
+
+// 是否按文件内容匹配
+func (h *Handler) matchContent(r io.ReaderAt, size int64) bool {
+	if r == nil {
+		return false
+	}
+	if len(h.Magic) > 0 && hasMagic(r, h.Magic) {
+		return true
+	}
+	return h.Match != nil && h.Match(r, size)
+}
+
+// 是否按扩展名匹配
+func (h *Handler) matchExt(ext string) bool {
+	for _, e := range h.Exts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	handlersMu sync.RWMutex
+	handlers   []*Handler
+)
+
+// 注册处理器，可以添加新格式，或者以相同及更高的优先级覆盖内置格式
+func RegisterHandler(h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	// 复制一份，不修改调用方的切片
+	exts := make([]string, len(h.Exts))
+	for i, e := range h.Exts {
+		exts[i] = strings.ToLower(e)
+	}
+	h.Exts = exts
+
+	// 优先级高的在前，相同优先级后注册的在前
+	handlers = append([]*Handler{&h}, handlers...)
+	sort.SliceStable(handlers, func(i, j int) bool {
+		return handlers[i].Priority > handlers[j].Priority
+	})
+}
+
+/*
+查找处理器，r为nil时只按扩展名匹配，zip包的中央目录只解析一次，条目数超过MaxZipEntries时不解析。
+先选优先级最高的匹配的处理器，相同优先级时按匹配程度：
+
+ 1. 扩展名和内容都匹配的
+ 2. 扩展名匹配，但处理器没有内容匹配规则的（无法判断内容是否相符）
+ 3. 内容匹配的（扩展名缺失、未知或者与内容不符）
+ 4. 扩展名匹配的
+*/
+func findHandler(r io.ReaderAt, size int64, name string, cfg ...Config) *Handler {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	if r != nil {
+		r = &sniffReader{ReaderAt: r, limits: limitsOf(cfg...)}
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	var best *Handler
+	bestRank := 0
+	for _, h := range handlers {
+		// handlers按优先级排序，找到后不再看优先级更低的
+		if best != nil && h.Priority < best.Priority {
+			break
+		}
+
+		rank := h.matchRank(ext, r, size)
+		if rank > 0 && (best == nil || rank < bestRank) {
+			best, bestRank = h, rank
+		}
+		if rank == 1 {
+			break
+		}
+	}
+	return best
+}
+
+// 匹配程度，对应findHandler中的顺序，0为不匹配
+func (h *Handler) matchRank(ext string, r io.ReaderAt, size int64) int {
+	e := h.matchExt(ext)
+	if e && !h.hasContent() {
+		return 2
+	}
+	switch c := h.matchContent(r, size); {
+	case e && c:
+		return 1
+	case c:
+		return 3
+	case e:
+		return 4
+	}
+	return 0
+}
+
+// 打开zip包，先检查条目数量，格式错误时返回CorruptError
+func openZip(r io.ReaderAt, size int64, format string, cfg ...Config) (*zip.Reader, error) {
+	if err := limitsOf(cfg...).checkZipDir(r, size); err != nil {
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &CorruptError{Format: format, Offset: -1, Err: err}
+	}
+	return zr, nil
+}
+
+// 内置的处理器
+func init() {
+	RegisterHandler(Handler{
+		Name:  "appledouble",
+		Magic: appleDoubleMagics,
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return AppleDouble2ICO(w, io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			rsrc, err := appleDoubleFork(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return rsrcIcons(rsrc, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			rsrc, err := parseAppleDouble(d)
+			if err != nil {
+				return nil, err
+			}
+			return inspectRSRC(rsrc, cfg...)
+		},
+	})
+
+	// https://superuser.com/questions/1480268/icons-no-longer-in-imageres-dll-in-windows-10-1903-4kb-file
+	RegisterHandler(Handler{
+		Name:  "pe",
+		Exts:  []string{".exe", ".dll", ".mui", ".mun"},
+		Match: isPE,
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			peFile, err := newPEFile(r)
+			if err != nil {
+				return err
+			}
+			return pe2ICO(w, peFile, cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			peFile, err := newPEFile(r)
+			if err != nil {
+				return nil, err
+			}
+			items, _, err := peItems(peFile, cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return itemIcons(items, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			return inspectPE(r, cfg...)
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name:  "ne",
+		Match: isNE,
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return fmt.Errorf("%w: NE executable", ErrUnsupportedFormat)
+		},
+		Info: func(r io.ReaderAt, size int64, name string) (Info, error) {
+			return Info{}, fmt.Errorf("%w: NE executable", ErrUnsupportedFormat)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			return nil, fmt.Errorf("%w: NE executable", ErrUnsupportedFormat)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			return nil, fmt.Errorf("%w: NE executable", ErrUnsupportedFormat)
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name:  "ico",
+		Exts:  []string{".ico", ".cur"},
+		Match: isICO,
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return ICO2ICO(w, io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return icoIcons(d, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return inspectICO(d, cfg...)
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name:  "icns",
+		Exts:  []string{".icns"},
+		Magic: []string{"icns"},
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return ICNS2ICO(w, io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			return loadICNS(io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return inspectICNS(d, "icns", cfg...)
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name: "rsrc",
+		Exts: []string{".rsrc"},
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return RSRC2ICO(w, io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return rsrcIcons(d, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return inspectRSRC(d, cfg...)
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name:  "image",
+		Exts:  []string{".bmp", ".gif", ".jpg", ".jpeg", ".png", ".tiff"},
+		Magic: imageMagics,
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			return IMG2ICO(w, io.NewSectionReader(r, 0, size), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			d, err := readAll(io.NewSectionReader(r, 0, size), cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return imageIcons(d, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			e, err := imageEntry(io.NewSectionReader(r, 0, size))
+			if err != nil {
+				return nil, err
+			}
+			return []IconSet{{Format: e.Encoding, Entries: []IconEntry{e}}}, nil
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name: "apk",
+		Exts: []string{".apk"},
+		Match: func(r io.ReaderAt, size int64) bool {
+			return zipKind(r, size) == "apk"
+		},
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			_, d, err := apkIcon(r, size, cfg...)
+			if err != nil {
+				return err
+			}
+			return IMG2ICO(w, bytes.NewReader(d), cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			_, d, err := apkIcon(r, size, cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return imageIcons(d, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			// 只读取启动图标的图片头，不解码
+			icon, d, err := apkIcon(r, size, cfg...)
+			if err != nil {
+				return nil, err
+			}
+			e, err := imageEntry(bytes.NewReader(d))
+			if err != nil {
+				return nil, err
+			}
+			return []IconSet{{Format: "apk", Name: icon, Entries: []IconEntry{e}}}, nil
+		},
+	})
+
+	RegisterHandler(Handler{
+		Name: "ipa",
+		Exts: []string{".ipa"},
+		Match: func(r io.ReaderAt, size int64) bool {
+			return zipKind(r, size) == "ipa"
+		},
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			zr, err := openZip(r, size, "ipa", cfg...)
+			if err != nil {
+				return err
+			}
+			return ipa2ICO(w, zr, cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			zr, err := openZip(r, size, "ipa", cfg...)
+			if err != nil {
+				return nil, err
+			}
+			d, err := ipaIcon(zr, cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return imageIcons(d, cfg...)
+		},
+		Inspect: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]IconSet, error) {
+			zr, err := openZip(r, size, "ipa", cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return inspectIPA(zr, cfg...)
+		},
+	})
+
+	// zip中 __MACOSX/ 下保存的自定义文件夹图标
+	RegisterHandler(Handler{
+		Name: "zip",
+		Exts: []string{".zip"},
+		Match: func(r io.ReaderAt, size int64) bool {
+			return zipKind(r, size) == "zip"
+		},
+		Convert: func(w io.Writer, r io.ReaderAt, size int64, name string, cfg ...Config) error {
+			zr, err := openZip(r, size, "zip", cfg...)
+			if err != nil {
+				return err
+			}
+			return zipMacIcon2ICO(w, zr, cfg...)
+		},
+		Load: func(r io.ReaderAt, size int64, name string, cfg ...Config) ([]Icon, error) {
+			zr, err := openZip(r, size, "zip", cfg...)
+			if err != nil {
+				return nil, err
+			}
+			rsrc, err := zipMacIcon(zr, cfg...)
+			if err != nil {
+				return nil, err
+			}
+			return rsrcIcons(rsrc, cfg...)
+		},
+	})
+
+	// 配置文件：autorun.inf、desktop.ini、*.desktop(*.AppImage/*.run)、.directory
+	RegisterHandler(Handler{
+		Name: "ini",
+		Exts: []string{".inf", ".ini", ".desktop", ".directory"},
+		Info: iniInfo,
+	})
+
+	RegisterHandler(Handler{
+		Name: "dmg",
+		Exts: []string{".dmg"},
+	})
+}
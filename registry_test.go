@@ -0,0 +1,38 @@
+package fico
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindHandlerPriority(t *testing.T) {
+	exts := []string{".FZT"}
+	RegisterHandler(Handler{Name: "fzt", Exts: exts, Magic: []string{"FZT1"}})
+	RegisterHandler(Handler{Name: "fzt ext", Exts: []string{".fzt"}, Priority: 1})
+	RegisterHandler(Handler{Name: "fzt content", Magic: []string{"FZT2"}})
+	if exts[0] != ".FZT" {
+		t.Fatalf("caller's Exts changed to %q", exts[0])
+	}
+
+	tests := []struct {
+		name, data, want string
+	}{
+		// 优先级高的只有扩展名匹配，也优先于扩展名和内容都匹配的
+		{"a.fzt", "FZT1", "fzt ext"},
+		{"a.FZT", "", "fzt ext"},
+		// 相同优先级时内容匹配优先于扩展名
+		{"a.bin", "FZT1", "fzt"},
+		{"a", "FZT2", "fzt content"},
+		{"a.bin", "none", ""},
+	}
+	for _, tt := range tests {
+		r := strings.NewReader(tt.data)
+		var got string
+		if h := findHandler(r, r.Size(), tt.name); h != nil {
+			got = h.Name
+		}
+		if got != tt.want {
+			t.Errorf("%s %q: got %q, want %q", tt.name, tt.data, got, tt.want)
+		}
+	}
+}
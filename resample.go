@@ -0,0 +1,262 @@
+package fico
+
+import (
+	"image"
+	"math"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// 缩放使用的滤波器，support为核函数的半径
+type filter struct {
+	support float64
+	kernel  func(x float64) float64
+}
+
+var filters = map[string]filter{
+	"box": {0.5, func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}},
+	"bilinear": {1, func(x float64) float64 {
+		return math.Max(0, 1-math.Abs(x))
+	}},
+	"catmullrom": {2, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return (1.5*x-2.5)*x*x + 1
+		}
+		if x < 2 {
+			return ((-0.5*x+2.5)*x-4)*x + 2
+		}
+		return 0
+	}},
+	"lanczos": {3, func(x float64) float64 {
+		if x == 0 {
+			return 1
+		}
+		if x <= -3 || x >= 3 {
+			return 0
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}},
+}
+
+// 缩小到32像素以下时锐化的强度
+const sharpenAmount = 0.35
+
+var (
+	// sRGB到线性光
+	srgbLinear [256]float32
+	// 线性光到sRGB，按1/4095量化
+	linearSRGB [4096]float32
+)
+
+func init() {
+	for i := range srgbLinear {
+		v := float64(i) / 0xFF
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		srgbLinear[i] = float32(v)
+	}
+	for i := range linearSRGB {
+		v := float64(i) / float64(len(linearSRGB)-1)
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		linearSRGB[i] = float32(v)
+	}
+}
+
+// 每个目标像素对应的源像素起点和权重
+type contrib struct {
+	start int
+	w     []float32
+}
+
+// 计算一个方向上的权重，缩小时核函数按比例展宽
+func weights(sn, dn int, f filter) []contrib {
+	scale := float64(sn) / float64(dn)
+	fs := math.Max(scale, 1)
+	support := f.support * fs
+
+	cs := make([]contrib, dn)
+	for i := range cs {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := max(int(math.Ceil(center-support)), 0)
+		hi := min(int(math.Floor(center+support)), sn-1)
+
+		var ws []float32
+		var sum float64
+		for j := lo; j <= hi; j++ {
+			wt := f.kernel((float64(j) - center) / fs)
+			ws = append(ws, float32(wt))
+			sum += wt
+		}
+
+		// 权重全为0时取最近的像素
+		if sum == 0 {
+			lo = min(max(int(math.Round(center)), 0), sn-1)
+			cs[i] = contrib{lo, []float32{1}}
+			continue
+		}
+		for j := range ws {
+			ws[j] /= float32(sum)
+		}
+		cs[i] = contrib{lo, ws}
+	}
+	return cs
+}
+
+// 源图转换为线性光、预乘Alpha的浮点数据，每个像素4个值
+func linearPremul(src image.Image, sr image.Rectangle) []float32 {
+	n, ok := src.(*image.NRGBA)
+	if !ok {
+		n = image.NewNRGBA(sr)
+		draw.Draw(n, sr, src, sr.Min, draw.Src)
+	}
+
+	sw, sh := sr.Dx(), sr.Dy()
+	buf := make([]float32, sw*sh*4)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			p := n.Pix[n.PixOffset(sr.Min.X+x, sr.Min.Y+y):]
+			a := float32(p[3]) / 0xFF
+			o := (y*sw + x) << 2
+			buf[o], buf[o+1], buf[o+2], buf[o+3] = srgbLinear[p[0]]*a, srgbLinear[p[1]]*a, srgbLinear[p[2]]*a, a
+		}
+	}
+	return buf
+}
+
+// 轻度的USM锐化，模糊使用3x3均值，边缘像素重复
+func sharpenLinear(in []float32, w, h int) []float32 {
+	out := make([]float32, len(in))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var blur [4]float32
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					o := (min(max(y+dy, 0), h-1)*w + min(max(x+dx, 0), w-1)) << 2
+					for c := 0; c < 4; c++ {
+						blur[c] += in[o+c] / 9
+					}
+				}
+			}
+
+			o := (y*w + x) << 2
+			for c := 0; c < 4; c++ {
+				out[o+c] = in[o+c] + sharpenAmount*(in[o+c]-blur[c])
+			}
+		}
+	}
+	return out
+}
+
+/*
+将src的sr部分缩放到dst的dr部分，Filter选择滤波器：
+
+	nearest     最近邻，像素画保持清晰的边缘和原有颜色
+	box         区域平均
+	bilinear    双线性
+	catmullrom  Catmull-Rom三次卷积（默认）
+	lanczos     Lanczos3
+
+除nearest外都在线性光空间中按预乘Alpha计算，避免半透明边缘出现暗边，
+Sharpen开启且缩小到32像素以下时再做一次轻度锐化，每处理一行检查一次ctx是否已经取消
+*/
+func resample(dst *image.RGBA, dr image.Rectangle, src image.Image, sr image.Rectangle, cfg ...Config) error {
+	if err := ctxErr(cfg...); err != nil {
+		return err
+	}
+	if dr.Empty() || sr.Empty() {
+		return nil
+	}
+
+	var name string
+	var sharpen bool
+	if len(cfg) > 0 {
+		name, sharpen = strings.ToLower(cfg[0].Filter), cfg[0].Sharpen
+	}
+	if name == "nearest" {
+		draw.NearestNeighbor.Scale(dst, dr, src, sr, draw.Src, nil)
+		return nil
+	}
+	f, ok := filters[name]
+	if !ok {
+		f = filters["catmullrom"]
+	}
+
+	sw, sh, dw, dh := sr.Dx(), sr.Dy(), dr.Dx(), dr.Dy()
+	in := linearPremul(src, sr)
+
+	// 先水平再垂直
+	xs := weights(sw, dw, f)
+	tmp := make([]float32, dw*sh*4)
+	for y := 0; y < sh; y++ {
+		if err := ctxErr(cfg...); err != nil {
+			return err
+		}
+		for x, c := range xs {
+			var acc [4]float32
+			for k, wt := range c.w {
+				o := (y*sw + c.start + k) << 2
+				acc[0], acc[1], acc[2], acc[3] = acc[0]+in[o]*wt, acc[1]+in[o+1]*wt, acc[2]+in[o+2]*wt, acc[3]+in[o+3]*wt
+			}
+			copy(tmp[(y*dw+x)<<2:], acc[:])
+		}
+	}
+
+	ys := weights(sh, dh, f)
+	out := make([]float32, dw*dh*4)
+	for y, c := range ys {
+		if err := ctxErr(cfg...); err != nil {
+			return err
+		}
+		for x := 0; x < dw; x++ {
+			var acc [4]float32
+			for k, wt := range c.w {
+				o := ((c.start+k)*dw + x) << 2
+				acc[0], acc[1], acc[2], acc[3] = acc[0]+tmp[o]*wt, acc[1]+tmp[o+1]*wt, acc[2]+tmp[o+2]*wt, acc[3]+tmp[o+3]*wt
+			}
+			copy(out[(y*dw+x)<<2:], acc[:])
+		}
+	}
+
+	if sharpen && (dw < sw || dh < sh) && max(dw, dh) < 32 {
+		out = sharpenLinear(out, dw, dh)
+	}
+
+	// 转换回sRGB，dst是预乘Alpha的
+	b := dst.Bounds()
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			if !image.Pt(dr.Min.X+x, dr.Min.Y+y).In(b) {
+				continue
+			}
+
+			o := (y*dw + x) << 2
+			a := min(max(out[o+3], 0), 1)
+			p := dst.Pix[dst.PixOffset(dr.Min.X+x, dr.Min.Y+y):]
+			if a <= 0 {
+				p[0], p[1], p[2], p[3] = 0, 0, 0, 0
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				v := min(max(out[o+c]/a, 0), 1)
+				p[c] = uint8(linearSRGB[int(v*float32(len(linearSRGB)-1)+0.5)]*a*0xFF + 0.5)
+			}
+			p[3] = uint8(a*0xFF + 0.5)
+		}
+	}
+	return nil
+}
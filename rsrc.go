@@ -0,0 +1,307 @@
+package fico
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tmc/icns"
+)
+
+// macOS自定义图标的资源ID（kCustomIconResource）
+const customIconID = -16455
+
+// macResource 经典Resource Manager资源分支中的一项资源
+type macResource struct {
+	Type string
+	ID   int16
+	Data []byte
+}
+
+/*
+AppleDouble/AppleSingle格式（RFC 1740），Mac文件拷贝到其他文件系统时，
+资源分支会单独保存到 ._文件名 中，zip压缩时则保存为 __MACOSX/.../._文件名。
+
+	Magic      uint32 // 0x00051607(AppleDouble) 或 0x00051600(AppleSingle)
+	Version    uint32 // 0x00020000
+	Filler     [16]byte
+	NumEntries uint16
+	Entries    []struct{ ID, Offset, Length uint32 } // ID为2的是资源分支
+*/
+func parseAppleDouble(d []byte) ([]byte, error) {
+	be := binary.BigEndian
+	if len(d) < 26 {
+		return nil, corrupt("appledouble", 0, "invalid AppleDouble header")
+	}
+	if magic := be.Uint32(d); magic != 0x00051607 && magic != 0x00051600 {
+		return nil, corrupt("appledouble", 0, "invalid AppleDouble magic")
+	}
+
+	n := int(be.Uint16(d[24:]))
+	for i := 0; i < n; i++ {
+		o := 26 + i*12
+		if o+12 > len(d) {
+			break
+		}
+		id, offset, length := be.Uint32(d[o:]), int64(be.Uint32(d[o+4:])), int64(be.Uint32(d[o+8:]))
+		if id != 2 {
+			continue
+		}
+		if offset+length > int64(len(d)) {
+			return nil, corrupt("appledouble", int64(o), "resource fork out of range")
+		}
+		return d[offset : offset+length], nil
+	}
+	return nil, fmt.Errorf("%w: no resource fork", ErrNoIcon)
+}
+
+/*
+经典Mac OS资源分支格式（Inside Macintosh: More Macintosh Toolbox, 1-121）：
+
+	Header   {DataOffset, MapOffset, DataLength, MapLength uint32}
+	Map      {Header [16]byte, Handle uint32, FileRef, Attrs uint16, TypeListOffset, NameListOffset uint16}
+	TypeList {Count-1 uint16, []{Type [4]byte, Count-1, RefListOffset uint16}}
+	RefList  []{ID int16, NameOffset uint16, Attrs uint8, DataOffset [3]byte, Handle uint32}
+	Data     []{Length uint32, Data []byte}
+*/
+func parseResourceFork(d []byte) ([]*macResource, error) {
+	be := binary.BigEndian
+	if len(d) < 16 {
+		return nil, corrupt("rsrc", 0, "invalid resource fork header")
+	}
+
+	dataOffset, mapOffset := int(be.Uint32(d)), int(be.Uint32(d[4:]))
+	if mapOffset < 0 || mapOffset+28 > len(d) {
+		return nil, corrupt("rsrc", 4, "resource map out of range")
+	}
+
+	typeList := mapOffset + int(be.Uint16(d[mapOffset+24:]))
+	if typeList+2 > len(d) {
+		return nil, corrupt("rsrc", int64(mapOffset+24), "resource type list out of range")
+	}
+
+	var res []*macResource
+	nTypes := int(be.Uint16(d[typeList:])) + 1
+	// 0xFFFF表示没有任何资源
+	if nTypes > 0xFFFF {
+		return nil, nil
+	}
+	for i := 0; i < nTypes; i++ {
+		t := typeList + 2 + i*8
+		if t+8 > len(d) {
+			return nil, corrupt("rsrc", int64(t), "resource type out of range")
+		}
+
+		typ := string(d[t : t+4])
+		nRefs := int(be.Uint16(d[t+4:])) + 1
+		refList := typeList + int(be.Uint16(d[t+6:]))
+		for j := 0; j < nRefs; j++ {
+			r := refList + j*12
+			if r+12 > len(d) {
+				return nil, corrupt("rsrc", int64(r), "resource reference out of range")
+			}
+
+			o := dataOffset + int(uint32(d[r+5])<<16|uint32(d[r+6])<<8|uint32(d[r+7]))
+			if o+4 > len(d) {
+				return nil, corrupt("rsrc", int64(r+5), "resource data out of range")
+			}
+			l := int(be.Uint32(d[o:]))
+			if l < 0 || o+4+l > len(d) {
+				return nil, corrupt("rsrc", int64(o), "resource data out of range")
+			}
+
+			res = append(res, &macResource{
+				Type: typ,
+				ID:   int16(be.Uint16(d[r:])),
+				Data: d[o+4 : o+4+l],
+			})
+		}
+	}
+	return res, nil
+}
+
+// 资源分支中可以组成icns的图标资源类型
+var legacyIconTypes = map[string]bool{
+	"ICN#": true, "icl4": true, "icl8": true,
+	"ics#": true, "ics4": true, "ics8": true,
+	"icm#": true, "icm4": true, "icm8": true,
+	"ich#": true, "ich4": true, "ich8": true,
+}
+
+// 从资源列表中选出图标族：优先使用icns资源，其次将ICN#、icl8等经典资源组装成icns
+func rsrcIconSet(res []*macResource) (icns.IconSet, error) {
+	// 优先使用自定义图标ID，没有的话使用ID最小的
+	sort.SliceStable(res, func(i, j int) bool {
+		if (res[i].ID == customIconID) != (res[j].ID == customIconID) {
+			return res[i].ID == customIconID
+		}
+		return res[i].ID < res[j].ID
+	})
+
+	for _, r := range res {
+		if r.Type == "icns" {
+			return parseICNS(r.Data, 0, nil)
+		}
+	}
+
+	var set icns.IconSet
+	id, found := int16(0), false
+	for _, r := range res {
+		if !legacyIconTypes[r.Type] || (found && r.ID != id) {
+			continue
+		}
+		id, found = r.ID, true
+
+		icon := &icns.Icon{Data: r.Data}
+		copy(icon.Type[:], r.Type)
+		set = append(set, icon)
+	}
+
+	if len(set) <= 0 {
+		return nil, fmt.Errorf("%w: no icon resource", ErrNoIcon)
+	}
+	return set, nil
+}
+
+// 资源分支（如 Icon\r/..namedfork/rsrc）转换为ico
+func RSRC2ICO(w io.Writer, r io.Reader, cfg ...Config) error {
+	d, err := rsrcICNS(r, cfg...)
+	if err != nil {
+		return err
+	}
+	return ICNS2ICO(w, bytes.NewReader(d), cfg...)
+}
+
+// 资源分支中的图标族写成icns
+func rsrcICNS(r io.Reader, cfg ...Config) ([]byte, error) {
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := parseResourceFork(d)
+	if err != nil {
+		return nil, err
+	}
+	if err = limitsOf(cfg...).checkResources(len(res)); err != nil {
+		return nil, err
+	}
+
+	set, err := rsrcIconSet(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err = writeICNS(&buf, set, false); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// AppleDouble文件（如 ._Icon\r）转换为ico
+func AppleDouble2ICO(w io.Writer, r io.Reader, cfg ...Config) error {
+	rsrc, err := appleDoubleFork(r, cfg...)
+	if err != nil {
+		return err
+	}
+	return RSRC2ICO(w, bytes.NewReader(rsrc), cfg...)
+}
+
+// AppleDouble文件中的资源分支
+func appleDoubleFork(r io.Reader, cfg ...Config) ([]byte, error) {
+	d, err := readAll(r, cfg...)
+	if err != nil {
+		return nil, err
+	}
+	return parseAppleDouble(d)
+}
+
+// 是否是macOS自定义图标文件：Icon\r 或者 AppleDouble的 ._Icon\r，其他 ._ 文件按AppleDouble处理器的文件头匹配
+func isMacIconFile(path string) bool {
+	base := filepath.Base(path)
+	return base == "Icon\r" || base == "._Icon\r"
+}
+
+// 读取macOS自定义图标，依次尝试macOS原生资源分支、同目录下的AppleDouble文件
+func macIcon2ICO(w io.Writer, fsys fileSystem, path string, cfg ...Config) error {
+	rsrc, err := macIconFork(fsys, path, cfg...)
+	if err != nil {
+		return err
+	}
+	return RSRC2ICO(w, bytes.NewReader(rsrc), cfg...)
+}
+
+// macOS自定义图标的资源分支
+func macIconFork(fsys fileSystem, path string, cfg ...Config) ([]byte, error) {
+	dir, base := filepath.Split(path)
+	if strings.HasPrefix(base, "._") {
+		d, err := readFile(fsys, path, cfg...)
+		if err != nil {
+			return nil, err
+		}
+		return parseAppleDouble(d)
+	}
+
+	d, err := readFile(fsys, fsys.Join(path, "..namedfork/rsrc"), cfg...)
+	if err == nil && len(d) > 0 {
+		return d, nil
+	}
+	if errors.Is(err, ErrLimitExceeded) {
+		return nil, err
+	}
+
+	if d, err = readFile(fsys, fsys.Join(dir, "._"+base), cfg...); err != nil {
+		return nil, err
+	}
+	return parseAppleDouble(d)
+}
+
+// zip包中 __MACOSX/ 下保存的自定义文件夹图标，选择最外层的那个
+func zipMacIcon2ICO(w io.Writer, r *zip.Reader, cfg ...Config) error {
+	rsrc, err := zipMacIcon(r, cfg...)
+	if err != nil {
+		return err
+	}
+	return RSRC2ICO(w, bytes.NewReader(rsrc), cfg...)
+}
+
+// zip包中最外层的自定义文件夹图标的资源分支
+func zipMacIcon(r *zip.Reader, cfg ...Config) ([]byte, error) {
+	l := limitsOf(cfg...)
+	if err := l.checkZip(r); err != nil {
+		return nil, err
+	}
+
+	var iconFile *zip.File
+	for _, f := range r.File {
+		if err := ctxErr(cfg...); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(f.Name, "__MACOSX/") && filepath.Base(f.Name) == "._Icon\r" &&
+			(iconFile == nil || len(f.Name) < len(iconFile.Name)) {
+			iconFile = f
+		}
+	}
+
+	if iconFile == nil {
+		return nil, fmt.Errorf("%w: no custom icon in zip", ErrNoIcon)
+	}
+	if err := l.checkZipFile(iconFile); err != nil {
+		return nil, err
+	}
+
+	rc, err := iconFile.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return appleDoubleFork(rc, cfg...)
+}
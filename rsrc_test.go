@@ -0,0 +1,33 @@
+package fico
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIsMacIconFile(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"Folder/Icon\r", true},
+		{"Folder/._Icon\r", true},
+		{"Icon\r", true},
+		{"Folder/._a.png", false},
+		{"Folder/._", false},
+		{"Folder/Icon", false},
+		{"Folder/._Icon", false},
+	}
+	for _, tt := range tests {
+		if got := isMacIconFile(tt.path); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// 其他 ._ 文件不是AppleDouble时是不支持的格式，而不是按资源分支读取
+	fsys := fstest.MapFS{"._a.txt": {Data: []byte("not an appledouble file")}}
+	if _, err := GetInfoFS(fsys, "._a.txt"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
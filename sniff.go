@@ -0,0 +1,121 @@
+package fico
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// 图片的文件头
+var imageMagics = []string{
+	"\x89PNG\r\n\x1a\n",
+	"GIF87a", "GIF89a",
+	"\xFF\xD8\xFF",
+	"BM",
+	"II*\x00", "MM\x00*",
+	"\x00\x00\x00\x0cjP  \r\n\x87\n", // JPEG 2000
+	"\xFF\x4F\xFF\x51",               // JPEG 2000 codestream
+}
+
+// AppleDouble/AppleSingle的文件头
+var appleDoubleMagics = []string{"\x00\x05\x16\x07", "\x00\x05\x16\x00"}
+
+// 是否以其中一个文件头开始
+func hasMagic(r io.ReaderAt, magics []string) bool {
+	n := 0
+	for _, m := range magics {
+		n = max(n, len(m))
+	}
+
+	hdr := make([]byte, n)
+	n, _ = r.ReadAt(hdr, 0)
+	for _, m := range magics {
+		if bytes.HasPrefix(hdr[:n], []byte(m)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MZ头中e_lfanew指向的新格式签名，PE为 PE\0\0，NE为 NE
+func exeSignature(r io.ReaderAt) string {
+	var hdr [0x40]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil || string(hdr[:2]) != "MZ" {
+		return ""
+	}
+
+	var sig [4]byte
+	n, _ := r.ReadAt(sig[:], int64(binary.LittleEndian.Uint32(hdr[0x3C:])))
+	return string(sig[:n])
+}
+
+// 是否是PE文件（exe、dll、mui、mun等）
+func isPE(r io.ReaderAt, size int64) bool {
+	return exeSignature(r) == "PE\x00\x00"
+}
+
+// 是否是16位Windows的NE文件
+func isNE(r io.ReaderAt, size int64) bool {
+	return strings.HasPrefix(exeSignature(r), "NE")
+}
+
+// ico（或cur）的文件头：保留字段为0，类型为1或2，至少有一个条目，且第一个条目的保留字段为0
+func isICO(r io.ReaderAt, size int64) bool {
+	var h [10]byte
+	if _, err := r.ReadAt(h[:], 0); err != nil {
+		return false
+	}
+	return h[0] == 0 && h[1] == 0 && (h[2] == 1 || h[2] == 2) && h[3] == 0 &&
+		(h[4] != 0 || h[5] != 0) && h[9] == 0
+}
+
+// sniffReader 查找处理器时传给内容匹配规则，缓存zip包的类型，避免每个处理器都解析一遍中央目录
+type sniffReader struct {
+	io.ReaderAt
+	limits  Limits
+	kind    string
+	zipDone bool
+}
+
+/*
+zip包的类型：
+
+	apk  zip中有 AndroidManifest.xml
+	ipa  zip中有 Payload/ 目录
+	zip  其他zip，以及条目数超过MaxZipEntries的zip（转换时返回LimitError）
+*/
+func zipKind(r io.ReaderAt, size int64) string {
+	s, ok := r.(*sniffReader)
+	if !ok {
+		return readZipKind(r, size, Limits{})
+	}
+	if !s.zipDone {
+		s.kind, s.zipDone = readZipKind(s.ReaderAt, size, s.limits), true
+	}
+	return s.kind
+}
+
+func readZipKind(r io.ReaderAt, size int64, l Limits) string {
+	if !hasMagic(r, []string{"PK\x03\x04", "PK\x05\x06"}) {
+		return ""
+	}
+	if l.checkZipDir(r, size) != nil {
+		return "zip"
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return ""
+	}
+	for _, f := range zr.File {
+		if f.Name == "AndroidManifest.xml" {
+			return "apk"
+		}
+		if strings.HasPrefix(f.Name, "Payload/") {
+			return "ipa"
+		}
+	}
+	return "zip"
+}
@@ -0,0 +1,56 @@
+package fico
+
+import (
+	"image"
+	"math"
+)
+
+// 不透明内容的范围，Alpha不超过threshold的像素视为透明
+func opaqueBounds(img image.Image, threshold uint8) image.Rectangle {
+	b := img.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X, b.Min.Y
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); uint8(a>>8) <= threshold {
+				continue
+			}
+			minX, minY = min(minX, x), min(minY, y)
+			maxX, maxY = max(maxX, x+1), max(maxY, y+1)
+		}
+	}
+	return image.Rect(minX, minY, maxX, maxY)
+}
+
+/*
+裁剪掉透明边缘，再按Margin留出统一的边距，按Fit放到w x h中（为0时保持原尺寸）：
+
+	48x48的位图只有中间32x32不透明，Margin为0.0625时，内容缩放到42x42，四周各留3像素
+*/
+func trimImg(img image.Image, w, h int, c Config) (*image.RGBA, error) {
+	b := img.Bounds()
+	if w <= 0 || h <= 0 {
+		w, h = b.Dx(), b.Dy()
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	content := opaqueBounds(img, c.TrimAlpha)
+	// 完全透明
+	if content.Empty() {
+		return dst, nil
+	}
+
+	// 安全区
+	margin := math.Min(math.Max(c.Margin, 0), 0.5)
+	mx, my := int(math.Round(float64(w)*margin)), int(math.Round(float64(h)*margin))
+	area := image.Rect(mx, my, w-mx, h-my)
+	if area.Empty() {
+		area = dst.Bounds()
+	}
+
+	// 按Fit放入安全区
+	dr, sr := fitRects(area, content, c.Fit)
+	if err := resample(dst, dr, img, sr, c); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}